@@ -0,0 +1,239 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// PuzzleDetails is returned by the admin puzzle endpoint
+type PuzzleDetails struct {
+	PuzzleInfo
+	Attempts       int32         `json:"attempts"`
+	Solved         bool          `json:"solved"`
+	Detached       bool          `json:"detached"`
+	ModuleProtocol string        `json:"module_protocol"`
+	RuleSet        string        `json:"rule_set"`
+	Clients        []WebConnInfo `json:"clients"`
+}
+
+// WebConnInfo describes a connected web client, the id is used to disconnect it
+type WebConnInfo struct {
+	Id string `json:"id"`
+	// Name is used for the web conn in the event log
+	Name        string `json:"name"`
+	Protocol    string `json:"protocol"`
+	TwitchPlays bool   `json:"twitch_plays"`
+}
+
+// GetPuzzle returns the active puzzle with the code
+func (r *RemoteMath) GetPuzzle(code string) *Puzzle {
+	r.puzzleLock.RLock()
+	defer r.puzzleLock.RUnlock()
+	return r.puzzles[strings.ToUpper(code)]
+}
+
+// KillPuzzle closes the puzzle even if the module is waiting to resume
+func (r *RemoteMath) KillPuzzle(code string) bool {
+	code = strings.ToUpper(code)
+	r.puzzleLock.Lock()
+	p := r.puzzles[code]
+	if p == nil {
+		r.puzzleLock.Unlock()
+		return false
+	}
+	if p.detachTimer != nil {
+		p.detachTimer.Stop()
+		p.detachTimer = nil
+	}
+	delete(r.respawn, code)
+	r.puzzleLock.Unlock()
+
+	p.log.Println("Killed by admin")
+	r.ClosePuzzle(p)
+	return true
+}
+
+// BroadcastNotice sends the notice to the module and web clients of every puzzle
+func (r *RemoteMath) BroadcastNotice(message string) {
+	r.puzzleLock.RLock()
+	defer r.puzzleLock.RUnlock()
+	for _, p := range r.puzzles {
+		if p != nil {
+			p.SendNotice(message)
+		}
+	}
+}
+
+// Details returns a snapshot of the puzzle state for the admin API
+func (p *Puzzle) Details() PuzzleDetails {
+	p.modConnLock.Lock()
+	detached := p.modConn == nil
+	p.modConnLock.Unlock()
+
+	p.webConnLock.RLock()
+	clients := make([]WebConnInfo, 0, len(p.webConns))
+	for _, w := range p.webConns {
+		clients = append(clients, WebConnInfo{
+			Id:          w.Id(),
+			Name:        w.name,
+			Protocol:    w.features.String(),
+			TwitchPlays: w.tpCode != "",
+		})
+	}
+	p.webConnLock.RUnlock()
+
+	return PuzzleDetails{
+		PuzzleInfo: PuzzleInfo{
+			Code:        p.code,
+			Date:        p.date,
+			TwitchPlays: p.twitchPlays,
+			WebConns:    len(clients),
+			Attempted:   p.attempts.Load() > 0,
+		},
+		Attempts:       p.attempts.Load(),
+		Solved:         p.solved.Load(),
+		Detached:       detached,
+		ModuleProtocol: p.modFeatures.String(),
+		RuleSet:        p.RuleSet().Id(),
+		Clients:        clients,
+	}
+}
+
+// DisconnectWebConn closes the web conn with the id, it can't rejoin afterwards
+func (p *Puzzle) DisconnectWebConn(id string) bool {
+	p.webConnLock.Lock()
+	defer p.webConnLock.Unlock()
+	for _, w := range p.webConns {
+		if w.Id() == id {
+			p.log.Println("Web client disconnected by admin")
+			w.resumeToken = ""
+			_ = w.conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// SendNotice sends a server notice to the module and web clients, modules
+// without the notice capability get it in the game log instead and web clients
+// without it can't show the notice so they are skipped
+func (p *Puzzle) SendNotice(message string) {
+	p.log.Printf("Server notice: %s\n", message)
+	p.event(Event{Type: EventNotice, Message: message})
+	if p.modFeatures.Has(protocol.CapabilityNotice) {
+		p.SendMod(protocol.ServerNotice{Message: message})
+	} else {
+		p.SendMod(protocol.PuzzleLog{Message: "ServerNotice: " + message})
+	}
+	p.webConnLock.RLock()
+	for _, w := range p.webConns {
+		if w.features.Has(protocol.CapabilityNotice) {
+			w.conn.Send(protocol.ServerNotice{Message: message})
+		}
+	}
+	p.webConnLock.RUnlock()
+}
+
+// adminAuth only calls the handler if the request has the admin bearer token
+// and uses the method, the admin API is hidden when no token is configured
+func (s *Server) adminAuth(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		token := s.conf.Load().Admin.Token
+		if token == "" {
+			http.NotFound(rw, req)
+			return
+		}
+		bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || !checkToken(token, bearer) {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if req.Method != method {
+			http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+// adminPuzzle returns the puzzle from the code query parameter or writes a not
+// found response
+func (s *Server) adminPuzzle(rw http.ResponseWriter, req *http.Request) *Puzzle {
+	code := req.URL.Query().Get("code")
+	if !regLogCode.MatchString(code) {
+		http.Error(rw, "Invalid puzzle code", http.StatusBadRequest)
+		return nil
+	}
+	p := s.rm.GetPuzzle(code)
+	if p == nil {
+		http.Error(rw, "Puzzle not found", http.StatusNotFound)
+	}
+	return p
+}
+
+// handleAdminPuzzles lists every active puzzle, the codes are enough to join a
+// puzzle so they are only shown to admins
+func (s *Server) handleAdminPuzzles(rw http.ResponseWriter, _ *http.Request) {
+	writeJson(rw, s.rm.ActivePuzzles())
+}
+
+func (s *Server) handleAdminPuzzle(rw http.ResponseWriter, req *http.Request) {
+	p := s.adminPuzzle(rw, req)
+	if p == nil {
+		return
+	}
+	writeJson(rw, p.Details())
+}
+
+func (s *Server) handleAdminKill(rw http.ResponseWriter, req *http.Request) {
+	code := req.URL.Query().Get("code")
+	if !regLogCode.MatchString(code) {
+		http.Error(rw, "Invalid puzzle code", http.StatusBadRequest)
+		return
+	}
+	if !s.rm.KillPuzzle(code) {
+		http.Error(rw, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[RemoteMath] Admin killed puzzle %s\n", strings.ToUpper(code))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminDisconnect(rw http.ResponseWriter, req *http.Request) {
+	p := s.adminPuzzle(rw, req)
+	if p == nil {
+		return
+	}
+	if !p.DisconnectWebConn(req.URL.Query().Get("conn")) {
+		http.Error(rw, "Web client not found", http.StatusNotFound)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminSaveLog(rw http.ResponseWriter, req *http.Request) {
+	p := s.adminPuzzle(rw, req)
+	if p == nil {
+		return
+	}
+	if err := s.rm.SaveLog(p); err != nil {
+		log.Printf("[RemoteMath] %s\n", err)
+		http.Error(rw, "Failed to save log", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminNotice(rw http.ResponseWriter, req *http.Request) {
+	message := strings.TrimSpace(req.FormValue("message"))
+	if message == "" {
+		http.Error(rw, "Missing message", http.StatusBadRequest)
+		return
+	}
+	log.Printf("[RemoteMath] Admin notice: %s\n", message)
+	s.rm.BroadcastNotice(message)
+	rw.WriteHeader(http.StatusNoContent)
+}
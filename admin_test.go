@@ -0,0 +1,180 @@
+package ktanemod_remote_math_server
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "0123456789abcdef"
+
+func testAdminRequest(s *Server, method, target string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	s.adminAuth(method, handler)(rec, req)
+	return rec
+}
+
+func TestServer_adminAuth(t *testing.T) {
+	conf := testConfig(t)
+	s := testServer(t, conf)
+	h := s.adminAuth(http.MethodPost, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	do := func(method, auth string) int {
+		req := httptest.NewRequest(method, "/api/admin/notice", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	// the admin API is disabled without a token
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "Bearer "))
+
+	conf.Admin.Token = testAdminToken
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, ""))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, testAdminToken))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "Bearer "+testAdminToken))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "Bearer "+testAdminToken))
+}
+
+func TestServer_handleAdminKill(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityResume}})
+
+	// detached puzzles waiting to resume can be killed too
+	_ = modServer.Close()
+	s.rm.DetachPuzzle(p, modServer)
+	rec := testAdminRequest(s, http.MethodPost, "/api/admin/kill?code="+strings.ToLower(p.code), s.handleAdminKill)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.True(t, p.checkKilled())
+	assert.Nil(t, s.rm.GetPuzzle(p.code))
+	assert.Empty(t, s.rm.respawn)
+
+	rec = testAdminRequest(s, http.MethodPost, "/api/admin/kill?code="+p.code, s.handleAdminKill)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = testAdminRequest(s, http.MethodPost, "/api/admin/kill?code=ABC", s.handleAdminKill)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_handleAdminDisconnect(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	webServer, webClient := testConnPair(t)
+	s.rm.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityResume}})
+
+	rec := testAdminRequest(s, http.MethodGet, "/api/admin/puzzle?code="+p.code, s.handleAdminPuzzle)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var details PuzzleDetails
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&details))
+	assert.Len(t, details.Clients, 1)
+	assert.Equal(t, "version 2 (resume)", details.Clients[0].Protocol)
+	assert.Equal(t, "expert-1", details.Clients[0].Name)
+	id := details.Clients[0].Id
+
+	rec = testAdminRequest(s, http.MethodPost, "/api/admin/disconnect?code="+p.code+"&conn=missing", s.handleAdminDisconnect)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = testAdminRequest(s, http.MethodPost, "/api/admin/disconnect?code="+p.code+"&conn="+id, s.handleAdminDisconnect)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	for {
+		if _, _, err := webClient.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	// the disconnected web conn can't rejoin
+	p.RemoveWebConn(webServer)
+	assert.Empty(t, p.departed)
+}
+
+func TestServer_handleAdminSaveLog(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+
+	rec := testAdminRequest(s, http.MethodPost, "/api/admin/save-log?code="+p.code, s.handleAdminSaveLog)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	b, err := os.ReadFile(filepath.Join(conf.LogDir, p.date.Format(time.DateOnly), p.code+".log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "Module ID: "+p.code)
+}
+
+func TestServer_handleAdminNotice(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	notice := protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityNotice}}
+	legacyServer, legacyClient := testConnPair(t)
+	s.rm.CreatePuzzle(legacyServer, protocol.Features{Version: 1})
+	modServer, modClient := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, notice)
+	webServer, webClient := testConnPair(t)
+	s.rm.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, notice)
+	for i := 0; i < 3; i++ {
+		// skip the puzzle state
+		readText(t, webClient)
+	}
+
+	rec := testAdminRequest(s, http.MethodPost, "/api/admin/notice?message=Restarting+soon", s.handleAdminNotice)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "PuzzleLog::ServerNotice: Restarting soon", readText(t, legacyClient))
+	assert.Equal(t, "ServerNotice::Restarting soon", readText(t, modClient))
+	assert.Equal(t, "ServerNotice::Restarting soon", readText(t, webClient))
+	assert.Contains(t, p.logRaw.String(), "Server notice: Restarting soon")
+
+	rec = testAdminRequest(s, http.MethodPost, "/api/admin/notice", s.handleAdminNotice)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_handleAdminPuzzles(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.RecvMod("PuzzleTwitchPlaysMode::42")
+	webServer, _ := testConnPair(t)
+	s.rm.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, protocol.Features{Version: 1})
+	p.RecvWebConn("PuzzleSolution::1::1::1+1*1=2::0")
+
+	rec := testAdminRequest(s, http.MethodGet, "/api/admin/puzzles", s.handleAdminPuzzles)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var puzzles []PuzzleInfo
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&puzzles))
+	assert.Len(t, puzzles, 1)
+	assert.Equal(t, p.code, puzzles[0].Code)
+	assert.True(t, puzzles[0].TwitchPlays)
+	assert.Equal(t, 1, puzzles[0].WebConns)
+	assert.True(t, puzzles[0].Attempted)
+
+	// closed puzzles are not listed
+	s.rm.ClosePuzzle(p)
+	rec = testAdminRequest(s, http.MethodGet, "/api/admin/puzzles", s.handleAdminPuzzles)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	// the codes are hidden without the admin token
+	rec = httptest.NewRecorder()
+	s.adminAuth(http.MethodGet, s.handleAdminPuzzles)(rec, httptest.NewRequest(http.MethodGet, "/api/admin/puzzles", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,73 @@
+package ktanemod_remote_math_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// StatusInfo is returned by the status endpoint
+type StatusInfo struct {
+	Started     time.Time `json:"started"`
+	Uptime      string    `json:"uptime"`
+	Connections int       `json:"connections"`
+	Puzzles     int       `json:"puzzles"`
+}
+
+// PuzzleInfo is a snapshot of an active puzzle
+type PuzzleInfo struct {
+	Code        string    `json:"code"`
+	Date        time.Time `json:"date"`
+	TwitchPlays bool      `json:"twitch_plays"`
+	WebConns    int       `json:"web_conns"`
+	Attempted   bool      `json:"attempted"`
+}
+
+// ActivePuzzles returns a snapshot of every puzzle which hasn't been closed
+func (r *RemoteMath) ActivePuzzles() []PuzzleInfo {
+	r.puzzleLock.RLock()
+	defer r.puzzleLock.RUnlock()
+	puzzles := make([]PuzzleInfo, 0, len(r.puzzles))
+	for _, p := range r.puzzles {
+		if p == nil {
+			continue
+		}
+		p.webConnLock.RLock()
+		webConns := len(p.webConns)
+		p.webConnLock.RUnlock()
+		puzzles = append(puzzles, PuzzleInfo{
+			Code:        p.code,
+			Date:        p.date,
+			TwitchPlays: p.twitchPlays,
+			WebConns:    webConns,
+			Attempted:   p.attempts.Load() > 0,
+		})
+	}
+	sort.Slice(puzzles, func(i, j int) bool {
+		return puzzles[i].Date.Before(puzzles[j].Date)
+	})
+	return puzzles
+}
+
+func (s *Server) handleStatus(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mLock.RLock()
+	connections := len(s.m)
+	s.mLock.RUnlock()
+	writeJson(rw, StatusInfo{
+		Started:     s.started,
+		Uptime:      time.Since(s.started).Round(time.Second).String(),
+		Connections: connections,
+		Puzzles:     len(s.rm.ActivePuzzles()),
+	})
+}
+
+func writeJson(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,27 @@
+package ktanemod_remote_math_server
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_handleStatus(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	s.started = time.Now().Add(-time.Minute)
+	modServer, _ := testConnPair(t)
+	s.m[modServer.RemoteAddr().String()] = modServer
+	s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+
+	rec := httptest.NewRecorder()
+	s.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var status StatusInfo
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, "1m0s", status.Uptime)
+	assert.Equal(t, 1, status.Connections)
+	assert.Equal(t, 1, status.Puzzles)
+}
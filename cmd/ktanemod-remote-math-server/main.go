@@ -3,18 +3,67 @@ package main
 import (
 	"flag"
 	remoteMath "github.com/MrMelon54/ktanemod-remote-math-server"
+	"log"
+	"os"
+	"time"
 )
 
+var configPath string
 var addr string
 var logDir string
 var debugPuzzle bool
+var pingInterval time.Duration
+var maxMissedPongs int
 
 func main() {
-	flag.StringVar(&addr, "addr", "localhost:8080", "service address")
-	flag.StringVar(&logDir, "logs", "logs/", "log storage directory")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(replay(os.Args[2:], os.Stdout, os.Stderr))
+		case "verify":
+			os.Exit(verify(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	flag.StringVar(&configPath, "config", "", "path to the yaml config file")
+	flag.StringVar(&addr, "addr", "", "service address, overrides the config file")
+	flag.StringVar(&logDir, "logs", "", "log storage directory, overrides the config file")
 	flag.BoolVar(&debugPuzzle, "d", false, "enable to show puzzle debug logs")
+	flag.DurationVar(&pingInterval, "ping", 0, "interval between pings, overrides the config file")
+	flag.IntVar(&maxMissedPongs, "missed-pongs", 0, "close connections after this many pings without a reply, overrides the config file")
 	flag.Parse()
 
-	s := &remoteMath.Server{Listen: addr, LogDir: logDir, DebugPuzzle: debugPuzzle}
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatalln("[RemoteMath] Invalid config:", err)
+	}
+
+	s := &remoteMath.Server{Config: conf, Reload: loadConfig}
 	s.Run()
 }
+
+// loadConfig reads the config file and environment variables, then applies
+// the command line flags on top
+func loadConfig() (*remoteMath.Config, error) {
+	conf, err := remoteMath.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// command line flags override the config file and environment variables
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			conf.Listen = addr
+		case "logs":
+			conf.LogDir = logDir
+		case "d":
+			conf.Debug = debugPuzzle
+		case "ping":
+			conf.Ping.Interval = pingInterval
+		case "missed-pongs":
+			conf.Ping.MaxMissedPongs = maxMissedPongs
+		}
+	})
+	return conf, conf.Validate()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	remoteMath "github.com/MrMelon54/ktanemod-remote-math-server"
+	"io"
+	"os"
+	"strings"
+)
+
+// replay checks the solution attempts in saved event logs against the current
+// rules, the exit code is 1 if any result changed
+func replay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: ktanemod-remote-math-server replay <event log>...")
+		_, _ = fmt.Fprintln(stderr, "Use - to read the event log from stdin, logs ending in .gz are decompressed.")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		result, err := replayFile(name)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %s\n", name, err)
+			return 2
+		}
+		_, _ = fmt.Fprintf(stdout, "%s: puzzle %s, %d attempts\n", name, result.Code, len(result.Attempts))
+		for _, i := range result.Attempts {
+			state := "ok"
+			if !i.Matches() {
+				state = "MISMATCH"
+				status = 1
+			}
+			_, _ = fmt.Fprintf(stdout, "  attempt %d: %d %d %s %d recorded %s replayed %s %s\n",
+				i.Attempt, i.Solution.Left, i.Solution.Right, i.Solution.Display, i.Solution.Status,
+				formatSteps(i.Recorded), formatSteps(i.Replayed), state)
+		}
+	}
+	return status
+}
+
+func replayFile(name string) (remoteMath.ReplayResult, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		// the janitor compresses the logs of previous days
+		data, err := readLogFile(name, strings.HasSuffix(name, ".gz"))
+		if err != nil {
+			return remoteMath.ReplayResult{}, err
+		}
+		r = bytes.NewReader(data)
+	}
+	events, err := remoteMath.ReadEvents(r)
+	if err != nil {
+		return remoteMath.ReplayResult{}, err
+	}
+	return remoteMath.Replay(events)
+}
+
+// formatSteps shows each step as Y if it was correct or N if it was wrong
+func formatSteps(s remoteMath.StepResults) string {
+	b := make([]byte, len(s))
+	for i, ok := range s {
+		if ok {
+			b[i] = 'Y'
+		} else {
+			b[i] = 'N'
+		}
+	}
+	return string(b)
+}
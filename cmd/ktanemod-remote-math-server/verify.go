@@ -0,0 +1,97 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	remoteMath "github.com/MrMelon54/ktanemod-remote-math-server"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verify checks saved log files against the signatures saved alongside them,
+// the exit code is 1 if any signature is missing or invalid
+func verify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keyPath := fs.String("key", "", "PEM encoded public key from /log/key or the signing private key")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: ktanemod-remote-math-server verify -key <key> <log file>...")
+		_, _ = fmt.Fprintln(stderr, "Log files must keep their saved path <date>/<code>.<kind>, the signature is read from <log file>.sig")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *keyPath == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	keyData, err := os.ReadFile(*keyPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 2
+	}
+	key, err := remoteMath.ParseVerifyKey(keyData)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s: %s\n", *keyPath, err)
+		return 2
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		err := verifyFile(key, name)
+		switch {
+		case err == nil:
+			_, _ = fmt.Fprintf(stdout, "%s: valid\n", name)
+		case errors.Is(err, remoteMath.ErrInvalidSignature), errors.Is(err, remoteMath.ErrLogNotSigned):
+			_, _ = fmt.Fprintf(stdout, "%s: %s\n", name, err)
+			status = 1
+		default:
+			_, _ = fmt.Fprintf(stderr, "%s: %s\n", name, err)
+			return 2
+		}
+	}
+	return status
+}
+
+func verifyFile(key ed25519.PublicKey, name string) error {
+	// the log id is part of the signed data
+	path, compressed := strings.CutSuffix(name, ".gz")
+	code, kind, _ := strings.Cut(filepath.Base(path), ".")
+	id := remoteMath.LogId{Date: filepath.Base(filepath.Dir(path)), Code: code, Kind: remoteMath.LogKind(kind)}
+	if !id.Valid() || id.Kind.IsSignature() {
+		return fmt.Errorf("path doesn't match <date>/<code>.<kind>")
+	}
+	data, err := readLogFile(name, compressed)
+	if err != nil {
+		return err
+	}
+	sig, err := os.ReadFile(path + ".sig")
+	if errors.Is(err, os.ErrNotExist) {
+		return remoteMath.ErrLogNotSigned
+	} else if err != nil {
+		return err
+	}
+	return remoteMath.VerifyLog(key, id, data, sig)
+}
+
+func readLogFile(name string, compressed bool) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !compressed {
+		return io.ReadAll(f)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(gz)
+}
@@ -0,0 +1,352 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is added to the start of every environment variable override
+const envPrefix = "REMOTE_MATH_"
+
+// Config contains the server settings loaded from the config file
+type Config struct {
+	Listen string `yaml:"listen"`
+	LogDir string `yaml:"log_dir"`
+	Debug  bool   `yaml:"debug"`
+
+	// LogStore selects where puzzle logs are saved
+	LogStore struct {
+		// Type is fs, sqlite or memory
+		Type       string `yaml:"type"`
+		SQLitePath string `yaml:"sqlite_path"`
+	} `yaml:"log_store"`
+
+	// Retention controls the background janitor which cleans up saved logs
+	Retention struct {
+		// MaxAge removes logs older than this, zero keeps logs forever
+		MaxAge time.Duration `yaml:"max_age"`
+		// MaxSize removes the oldest logs once the total size in bytes is
+		// larger than this, zero disables the limit
+		MaxSize int64 `yaml:"max_size"`
+		// Compress gzips the logs of previous days
+		Compress bool `yaml:"compress"`
+		// Interval is how often the janitor runs
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"retention"`
+
+	// Origins contains the OriginRule lists allowed for each client type
+	Origins struct {
+		Module []string `yaml:"module"`
+		Web    []string `yaml:"web"`
+
+		// the parsed rules are kept so requests don't parse them again
+		moduleRules OriginRules
+		webRules    OriginRules
+	} `yaml:"origins"`
+
+	Ping struct {
+		Interval       time.Duration `yaml:"interval"`
+		MaxMissedPongs int           `yaml:"max_missed_pongs"`
+	} `yaml:"ping"`
+
+	Puzzle struct {
+		// SolveCloseDelay is how long to wait before closing the module
+		// connection after the puzzle is solved
+		SolveCloseDelay time.Duration `yaml:"solve_close_delay"`
+		// ResumeGracePeriod is how long a puzzle waits for the module to resume
+		// and how long web clients can rejoin
+		ResumeGracePeriod time.Duration `yaml:"resume_grace_period"`
+	} `yaml:"puzzle"`
+
+	// TLS serves https directly when the cert and key are set, the files are
+	// read again when the config is reloaded
+	TLS struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+		// RedirectListen is an optional address for redirecting http to https
+		RedirectListen string `yaml:"redirect_listen"`
+	} `yaml:"tls"`
+
+	HTTP struct {
+		ReadTimeout       time.Duration `yaml:"read_timeout"`
+		ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+		WriteTimeout      time.Duration `yaml:"write_timeout"`
+		IdleTimeout       time.Duration `yaml:"idle_timeout"`
+		MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	} `yaml:"http"`
+
+	Shutdown struct {
+		// ReadyDelay is how long the readiness check fails before connections
+		// are closed, so load balancers stop sending new clients
+		ReadyDelay time.Duration `yaml:"ready_delay"`
+		// DrainTimeout is how long to wait for active puzzles to finish before
+		// they are killed
+		DrainTimeout time.Duration `yaml:"drain_timeout"`
+	} `yaml:"shutdown"`
+
+	// Signing signs saved logs when the key file is set
+	Signing struct {
+		// KeyFile is the PEM encoded Ed25519 private key, a new key is
+		// generated when the file doesn't exist
+		KeyFile string `yaml:"key_file"`
+	} `yaml:"signing"`
+
+	// Admin enables the admin API when the token is set
+	Admin struct {
+		Token string `yaml:"token" secret:"true"`
+	} `yaml:"admin"`
+}
+
+// DefaultConfig returns the settings used when no config file is provided
+func DefaultConfig() *Config {
+	c := &Config{
+		Listen: "localhost:8080",
+		LogDir: "logs/",
+	}
+	c.LogStore.Type = "fs"
+	c.LogStore.SQLitePath = "logs.db"
+	c.Retention.Interval = time.Hour
+	// modules run inside the game and don't send an Origin header
+	c.Origins.Module = []string{""}
+	c.Origins.Web = []string{"https://remote-math.mrmelon54.com", "localhost:*", "127.0.0.1:*"}
+	_ = c.parseOrigins()
+	c.Ping.Interval = 5 * time.Second
+	c.Ping.MaxMissedPongs = 3
+	c.Puzzle.SolveCloseDelay = 5 * time.Second
+	c.Puzzle.ResumeGracePeriod = 2 * time.Minute
+	c.HTTP.ReadTimeout = time.Minute
+	c.HTTP.ReadHeaderTimeout = time.Minute
+	c.HTTP.WriteTimeout = time.Minute
+	c.HTTP.IdleTimeout = time.Minute
+	c.HTTP.MaxHeaderBytes = 2500
+	c.Shutdown.DrainTimeout = time.Minute
+	return c
+}
+
+// LoadConfig reads the config file on top of the defaults, applies environment
+// variable overrides and validates the result
+//
+// An empty path skips reading the config file.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open config file: %w", err)
+		}
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+	}
+	if err := c.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type envVar struct {
+	name  string
+	field any
+}
+
+// envVars lists each environment variable suffix and the config field it sets
+func (c *Config) envVars() []envVar {
+	return []envVar{
+		{"LISTEN", &c.Listen},
+		{"LOG_DIR", &c.LogDir},
+		{"DEBUG", &c.Debug},
+		{"LOG_STORE_TYPE", &c.LogStore.Type},
+		{"LOG_STORE_SQLITE_PATH", &c.LogStore.SQLitePath},
+		{"RETENTION_MAX_AGE", &c.Retention.MaxAge},
+		{"RETENTION_MAX_SIZE", &c.Retention.MaxSize},
+		{"RETENTION_COMPRESS", &c.Retention.Compress},
+		{"RETENTION_INTERVAL", &c.Retention.Interval},
+		{"ORIGINS_MODULE", &c.Origins.Module},
+		{"ORIGINS_WEB", &c.Origins.Web},
+		{"PING_INTERVAL", &c.Ping.Interval},
+		{"PING_MAX_MISSED_PONGS", &c.Ping.MaxMissedPongs},
+		{"PUZZLE_SOLVE_CLOSE_DELAY", &c.Puzzle.SolveCloseDelay},
+		{"PUZZLE_RESUME_GRACE_PERIOD", &c.Puzzle.ResumeGracePeriod},
+		{"TLS_CERT", &c.TLS.Cert},
+		{"TLS_KEY", &c.TLS.Key},
+		{"TLS_REDIRECT_LISTEN", &c.TLS.RedirectListen},
+		{"HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", &c.HTTP.ReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout},
+		{"HTTP_MAX_HEADER_BYTES", &c.HTTP.MaxHeaderBytes},
+		{"SHUTDOWN_READY_DELAY", &c.Shutdown.ReadyDelay},
+		{"SHUTDOWN_DRAIN_TIMEOUT", &c.Shutdown.DrainTimeout},
+		{"SIGNING_KEY_FILE", &c.Signing.KeyFile},
+		{"ADMIN_TOKEN", &c.Admin.Token},
+	}
+}
+
+// loadEnv overrides config fields with environment variables, lists are
+// comma separated
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for _, env := range c.envVars() {
+		v, ok := lookup(envPrefix + env.name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch field := env.field.(type) {
+		case *string:
+			*field = v
+		case *bool:
+			*field, err = strconv.ParseBool(v)
+		case *int:
+			*field, err = strconv.Atoi(v)
+		case *int64:
+			*field, err = strconv.ParseInt(v, 10, 64)
+		case *time.Duration:
+			*field, err = time.ParseDuration(v)
+		case *[]string:
+			*field = strings.Split(v, ",")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for %s%s: %w", envPrefix, env.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks every field and returns all the problems found, the origin
+// rules are parsed again so the config must be validated after changing them
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, msg string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config %s: %s", field, msg))
+		}
+	}
+	check(c.Listen != "", "listen", "must not be empty")
+	check(c.LogDir != "", "log_dir", "must not be empty")
+	switch c.LogStore.Type {
+	case "fs", "memory":
+	case "sqlite":
+		check(c.LogStore.SQLitePath != "", "log_store.sqlite_path", "must not be empty")
+	default:
+		check(false, "log_store.type", "must be fs, sqlite or memory")
+	}
+	check(c.Retention.MaxAge >= 0, "retention.max_age", "must not be negative")
+	check(c.Retention.MaxSize >= 0, "retention.max_size", "must not be negative")
+	check(c.Retention.Interval > 0, "retention.interval", "must be positive")
+	check(len(c.Origins.Module) > 0, "origins.module", "must contain at least one origin")
+	check(len(c.Origins.Web) > 0, "origins.web", "must contain at least one origin")
+	if err := c.parseOrigins(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Ping.Interval > 0, "ping.interval", "must be positive")
+	check(c.Ping.MaxMissedPongs > 0, "ping.max_missed_pongs", "must be positive")
+	check(c.Puzzle.SolveCloseDelay >= 0, "puzzle.solve_close_delay", "must not be negative")
+	check(c.Puzzle.ResumeGracePeriod > 0, "puzzle.resume_grace_period", "must be positive")
+	check((c.TLS.Cert == "") == (c.TLS.Key == ""), "tls", "cert and key must both be set")
+	check(c.TLS.RedirectListen == "" || c.TLSEnabled(), "tls.redirect_listen", "requires tls cert and key")
+	check(c.TLS.RedirectListen == "" || c.TLS.RedirectListen != c.Listen, "tls.redirect_listen", "must be different from listen")
+	check(c.HTTP.ReadTimeout >= 0, "http.read_timeout", "must not be negative")
+	check(c.HTTP.ReadHeaderTimeout >= 0, "http.read_header_timeout", "must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "http.write_timeout", "must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "http.idle_timeout", "must not be negative")
+	check(c.HTTP.MaxHeaderBytes > 0, "http.max_header_bytes", "must be positive")
+	check(c.Shutdown.ReadyDelay >= 0, "shutdown.ready_delay", "must not be negative")
+	check(c.Shutdown.DrainTimeout >= 0, "shutdown.drain_timeout", "must not be negative")
+	check(c.Admin.Token == "" || len(c.Admin.Token) >= 16, "admin.token", "must be at least 16 characters")
+	return errors.Join(errs...)
+}
+
+// ConfigChange is a single field which is different between two configs
+type ConfigChange struct {
+	Field    string
+	Old, New any
+	// Restart is true if the change only applies after restarting the server
+	Restart bool
+}
+
+// restartFields are only read when the server starts
+var restartFields = []string{"listen", "log_store.", "tls.redirect_listen", "http.", "signing."}
+
+// Changes lists the fields which are different from the old config
+func (c *Config) Changes(old *Config) []ConfigChange {
+	var changes []ConfigChange
+	diffFields("", reflect.ValueOf(old).Elem(), reflect.ValueOf(c).Elem(), &changes)
+	return changes
+}
+
+// diffFields compares each field of the structs using the yaml names, the
+// values of secret fields are hidden
+func diffFields(prefix string, a, b reflect.Value, changes *[]ConfigChange) {
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if a.Field(i).Kind() == reflect.Struct {
+			diffFields(name+".", a.Field(i), b.Field(i), changes)
+			continue
+		}
+		oldV, newV := a.Field(i).Interface(), b.Field(i).Interface()
+		if reflect.DeepEqual(oldV, newV) {
+			continue
+		}
+		if field.Tag.Get("secret") == "true" {
+			oldV, newV = "[hidden]", "[hidden]"
+		}
+		restart := false
+		for _, f := range restartFields {
+			if name == f || (strings.HasSuffix(f, ".") && strings.HasPrefix(name, f)) {
+				restart = true
+			}
+		}
+		*changes = append(*changes, ConfigChange{Field: name, Old: oldV, New: newV, Restart: restart})
+	}
+}
+
+// TLSEnabled returns true if the server should serve https
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.Cert != "" && c.TLS.Key != ""
+}
+
+// parseOrigins parses the origin rules of both client types
+func (c *Config) parseOrigins() error {
+	var errs []error
+	var err error
+	if c.Origins.moduleRules, err = ParseOriginRules(c.Origins.Module); err != nil {
+		errs = append(errs, fmt.Errorf("config origins.module: %w", err))
+	}
+	if c.Origins.webRules, err = ParseOriginRules(c.Origins.Web); err != nil {
+		errs = append(errs, fmt.Errorf("config origins.web: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// ModuleOrigins returns the parsed origin rules for module clients
+func (c *Config) ModuleOrigins() OriginRules {
+	return c.Origins.moduleRules
+}
+
+// WebOrigins returns the parsed origin rules for web clients
+func (c *Config) WebOrigins() OriginRules {
+	return c.Origins.webRules
+}
+
+// PingConfig returns the ping settings for new connections
+func (c *Config) PingConfig() PingConfig {
+	return PingConfig{Interval: c.Ping.Interval, MaxMissedPongs: c.Ping.MaxMissedPongs}
+}
@@ -0,0 +1,100 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testConfig returns the default config with logs saved to a temporary directory
+func testConfig(t *testing.T) *Config {
+	c := DefaultConfig()
+	c.LogDir = t.TempDir()
+	return c
+}
+
+func TestLoadConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(p, []byte(`listen: ":9000"
+origins:
+  web: ["example.com"]
+ping:
+  interval: 10s
+http:
+  max_header_bytes: 4096
+`), 0600))
+	t.Setenv("REMOTE_MATH_LOG_DIR", "/var/log/remote-math")
+	t.Setenv("REMOTE_MATH_PING_MAX_MISSED_PONGS", "5")
+
+	c, err := LoadConfig(p)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9000", c.Listen)
+	assert.Equal(t, "/var/log/remote-math", c.LogDir)
+	assert.Equal(t, []string{"example.com"}, c.Origins.Web)
+	assert.Equal(t, []string{""}, c.Origins.Module)
+	assert.True(t, c.ModuleOrigins().Match(""))
+	assert.False(t, c.ModuleOrigins().Match("https://example.com"))
+	assert.True(t, c.WebOrigins().Match("https://example.com"))
+	assert.Equal(t, 10*time.Second, c.Ping.Interval)
+	assert.Equal(t, 5, c.Ping.MaxMissedPongs)
+	assert.Equal(t, 4096, c.HTTP.MaxHeaderBytes)
+
+	// unchanged fields keep the defaults
+	assert.Equal(t, 5*time.Second, c.Puzzle.SolveCloseDelay)
+	assert.Equal(t, time.Minute, c.HTTP.ReadTimeout)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(p, []byte("listen: \"\"\nping:\n  interval: -1s\n"), 0600))
+	_, err := LoadConfig(p)
+	assert.ErrorContains(t, err, "config listen: must not be empty")
+	assert.ErrorContains(t, err, "config ping.interval: must be positive")
+
+	assert.NoError(t, os.WriteFile(p, []byte("unknown: true\n"), 0600))
+	_, err = LoadConfig(p)
+	assert.ErrorContains(t, err, "field unknown not found")
+
+	t.Setenv("REMOTE_MATH_DEBUG", "maybe")
+	_, err = LoadConfig("")
+	assert.ErrorContains(t, err, "invalid value for REMOTE_MATH_DEBUG")
+}
+
+func TestLoadConfig_Example(t *testing.T) {
+	c, err := LoadConfig("config.example.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), c)
+}
+
+func TestConfig_Changes(t *testing.T) {
+	old := DefaultConfig()
+	c := DefaultConfig()
+	assert.Empty(t, c.Changes(old))
+
+	c.Listen = ":9000"
+	c.Debug = true
+	c.Origins.Web = []string{"example.com"}
+	c.HTTP.IdleTimeout = time.Second
+	c.Admin.Token = "0123456789abcdef"
+	assert.Equal(t, []ConfigChange{
+		{Field: "listen", Old: "localhost:8080", New: ":9000", Restart: true},
+		{Field: "debug", Old: false, New: true},
+		{Field: "origins.web", Old: old.Origins.Web, New: c.Origins.Web},
+		{Field: "http.idle_timeout", Old: time.Minute, New: time.Second, Restart: true},
+		{Field: "admin.token", Old: "[hidden]", New: "[hidden]"},
+	}, c.Changes(old))
+}
+
+func TestConfig_Validate_TLS(t *testing.T) {
+	c := DefaultConfig()
+	c.TLS.Cert = "cert.pem"
+	c.TLS.RedirectListen = ":80"
+	assert.ErrorContains(t, c.Validate(), "config tls: cert and key must both be set")
+	assert.ErrorContains(t, c.Validate(), "config tls.redirect_listen: requires tls cert and key")
+
+	c.TLS.Key = "key.pem"
+	assert.NoError(t, c.Validate())
+	assert.True(t, c.TLSEnabled())
+}
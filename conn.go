@@ -0,0 +1,198 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/gorilla/websocket"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sendQueueSize is the number of messages buffered for each connection,
+	// a client which falls this far behind is disconnected
+	sendQueueSize = 64
+	// writeTimeout is the deadline for writing a single message
+	writeTimeout = 10 * time.Second
+)
+
+// PingConfig controls how often connections are pinged and when a silent peer
+// is considered dead
+type PingConfig struct {
+	// Interval between pings, zero disables pings and read deadlines
+	Interval time.Duration
+	// MaxMissedPongs is the number of pings without any reply before the
+	// connection is closed
+	MaxMissedPongs int
+}
+
+// readTimeout is how long to wait for any message or pong before the peer is dead
+func (p PingConfig) readTimeout() time.Duration {
+	return p.Interval * time.Duration(p.MaxMissedPongs+1)
+}
+
+// Conn wraps a websocket connection so all writes happen on a single goroutine
+//
+// Messages are queued by Send and written in order by the writer goroutine.
+// If the queue fills up the client is too slow to keep up and the connection
+// is closed.
+//
+// The writer goroutine also sends websocket ping control frames, and the text
+// "ping" packet for legacy clients. Any message or pong from the peer extends
+// the read deadline.
+type Conn struct {
+	ws         *websocket.Conn
+	origin     string
+	ping       PingConfig
+	legacyPing *atomic.Bool
+	send       chan []byte
+	closed     chan struct{}
+	closeOnce  *sync.Once
+	done       chan struct{}
+}
+
+func NewConn(ws *websocket.Conn, ping PingConfig) *Conn {
+	c := &Conn{
+		ws:         ws,
+		ping:       ping,
+		legacyPing: new(atomic.Bool),
+		send:       make(chan []byte, sendQueueSize),
+		closed:     make(chan struct{}),
+		closeOnce:  new(sync.Once),
+		done:       make(chan struct{}),
+	}
+	c.legacyPing.Store(true)
+	if ping.Interval > 0 {
+		c.extendReadDeadline()
+		ws.SetPongHandler(func(string) error {
+			c.extendReadDeadline()
+			return nil
+		})
+	}
+	go c.writer()
+	return c
+}
+
+// SetLegacyPing enables or disables sending the text "ping" packet, control
+// frame pings are always sent
+func (c *Conn) SetLegacyPing(enabled bool) {
+	c.legacyPing.Store(enabled)
+}
+
+func (c *Conn) extendReadDeadline() {
+	_ = c.ws.SetReadDeadline(time.Now().Add(c.ping.readTimeout()))
+}
+
+// Send queues the packet to be written, it returns false if the connection is
+// closed or too slow to keep up
+func (c *Conn) Send(p protocol.Packet) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+	select {
+	case c.send <- []byte(protocol.Encode(p)):
+		return true
+	default:
+		log.Printf("[Websocket] Send queue full for '%s', closing slow connection\n", c.RemoteAddr())
+		_ = c.Close()
+		return false
+	}
+}
+
+// ReadMessage reads the next message, this must only be called from one goroutine
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = c.ws.ReadMessage()
+	if err == nil && c.ping.Interval > 0 {
+		c.extendReadDeadline()
+	}
+	return
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// Close stops accepting new messages, the writer goroutine flushes anything
+// already queued and then closes the underlying connection
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Wait blocks until the underlying connection is closed
+func (c *Conn) Wait() {
+	<-c.done
+}
+
+func (c *Conn) write(messageType int, b []byte) error {
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.ws.WriteMessage(messageType, b)
+}
+
+func (c *Conn) writer() {
+	defer close(c.done)
+	defer func() {
+		_ = c.ws.Close()
+	}()
+
+	// a nil channel blocks forever so pings are disabled without an interval
+	var pingC <-chan time.Time
+	if c.ping.Interval > 0 {
+		t := time.NewTicker(c.ping.Interval)
+		defer t.Stop()
+		pingC = t.C
+	}
+
+	for {
+		select {
+		case <-pingC:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				_ = c.Close()
+				return
+			}
+			if c.legacyPing.Load() {
+				if err := c.write(websocket.TextMessage, []byte(protocol.Encode(protocol.Ping{}))); err != nil {
+					_ = c.Close()
+					return
+				}
+			}
+		case b := <-c.send:
+			if err := c.write(websocket.TextMessage, b); err != nil {
+				_ = c.Close()
+				return
+			}
+		case <-c.closed:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush writes the queued messages followed by a close message
+func (c *Conn) flush() {
+	for {
+		select {
+		case b := <-c.send:
+			if err := c.write(websocket.TextMessage, b); err != nil {
+				return
+			}
+		default:
+			_ = c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConn_CloseFlushesQueue(t *testing.T) {
+	c, client := testConnPair(t)
+	assert.True(t, c.Send(protocol.PuzzleConnected{}))
+	assert.True(t, c.Send(protocol.PuzzleStrike{}))
+	assert.NoError(t, c.Close())
+	assert.False(t, c.Send(protocol.PuzzleComplete{}))
+
+	assert.Equal(t, "PuzzleConnected", readText(t, client))
+	assert.Equal(t, "PuzzleStrike", readText(t, client))
+	_, _, err := client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	c.Wait()
+}
+
+func TestConn_ConcurrentSend(t *testing.T) {
+	c, client := testConnPair(t)
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				c.Send(protocol.Ping{})
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 40; i++ {
+		assert.Equal(t, "ping", readText(t, client))
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
+
+func TestConn_DeadPeer(t *testing.T) {
+	c, _ := testConnPairPing(t, PingConfig{Interval: 20 * time.Millisecond, MaxMissedPongs: 2})
+
+	// the client never reads so never replies to the control frame pings
+	start := time.Now()
+	_, _, err := c.ReadMessage()
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
+
+func TestConn_Ping(t *testing.T) {
+	c, client := testConnPairPing(t, PingConfig{Interval: 20 * time.Millisecond, MaxMissedPongs: 2})
+	pings := make(chan struct{}, 10)
+	client.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return client.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// legacy clients get the text ping as well
+	assert.Equal(t, "ping", readText(t, client))
+	<-pings
+
+	// the connection stays open while the client replies to control frames
+	c.SetLegacyPing(false)
+	_ = client.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	_, _, err := client.ReadMessage()
+	assert.True(t, os.IsTimeout(err) || errors.Is(err, os.ErrDeadlineExceeded), "unexpected error: %v", err)
+	assert.GreaterOrEqual(t, len(pings), 4)
+}
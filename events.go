@@ -0,0 +1,92 @@
+package ktanemod_remote_math_server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// event types recorded in the structured puzzle log
+const (
+	EventCreated         = "created"
+	EventModuleDetached  = "module_detached"
+	EventModuleResumed   = "module_resumed"
+	EventTwitchPlays     = "twitch_plays"
+	EventTraining        = "training"
+	EventFruits          = "fruits"
+	EventBombDetails     = "bomb_details"
+	EventRuleSet         = "rule_set"
+	EventWebConnected    = "web_connected"
+	EventWebRejoined     = "web_rejoined"
+	EventWebDisconnected = "web_disconnected"
+	EventTwitchActivated = "twitch_activated"
+	EventSolution        = "solution"
+	EventSolved          = "solved"
+	EventNotice          = "notice"
+	EventClosed          = "closed"
+)
+
+// Event is a single line in the structured puzzle log, only the fields used
+// by the event type are set
+//
+// Web conns are named like expert-1 in Conn, their addresses aren't saved since
+// anyone with the date and code can read the event log.
+type Event struct {
+	Time       time.Time      `json:"time"`
+	Type       string         `json:"type"`
+	Code       string         `json:"code,omitempty"`
+	Protocol   string         `json:"protocol,omitempty"`
+	Conn       string         `json:"conn,omitempty"`
+	FruitText  *[2]int        `json:"fruit_text,omitempty"`
+	Fruits     *[8]int        `json:"fruits,omitempty"`
+	Batteries  *int           `json:"batteries,omitempty"`
+	Ports      *int           `json:"ports,omitempty"`
+	TwitchId   string         `json:"twitch_id,omitempty"`
+	TwitchCode string         `json:"twitch_code,omitempty"`
+	Attempt    int            `json:"attempt,omitempty"`
+	Solution   *SolutionEvent `json:"solution,omitempty"`
+	Steps      *StepResults   `json:"steps,omitempty"`
+	Correct    *bool          `json:"correct,omitempty"`
+	Message    string         `json:"message,omitempty"`
+	RuleSet    string         `json:"rule_set,omitempty"`
+}
+
+// SolutionEvent is the solution sent by the expert
+type SolutionEvent struct {
+	Left    int    `json:"left"`
+	Right   int    `json:"right"`
+	Display string `json:"display"`
+	Status  int    `json:"status"`
+}
+
+// event adds a timestamped event to the structured puzzle log
+func (p *Puzzle) event(e Event) {
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		// all the event fields can be encoded
+		panic(err)
+	}
+	_, _ = p.events.Write(append(b, '\n'))
+}
+
+// ReadEvents parses a structured puzzle log
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid event on line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, s.Err()
+}
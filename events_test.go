@@ -0,0 +1,75 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPuzzle_event(t *testing.T) {
+	logs := NewMemoryLogStore()
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), logs)
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	cText := p.cText
+	p.cText = cText1
+	p.RecvMod("PuzzleFruits::1::3::4::1::0::3::5::2")
+	p.RecvMod("BombDetails::2::3")
+	webServer, _ := testConnPair(t)
+	r.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, protocol.Features{Version: 1})
+	p.RecvWebConn("PuzzleSolution::2::13::14+91*5=469::2")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	p.RemoveWebConn(webServer)
+	r.ClosePuzzle(p)
+
+	f, _, err := logs.Open(p.LogId(LogEvents))
+	assert.NoError(t, err)
+	events, err := ReadEvents(f)
+	assert.NoError(t, err)
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+		assert.False(t, e.Time.IsZero())
+	}
+	assert.Equal(t, []string{
+		EventCreated, EventFruits, EventBombDetails, EventWebConnected,
+		EventSolution, EventSolution, EventSolved, EventWebDisconnected, EventClosed,
+	}, types)
+
+	assert.Equal(t, p.code, events[0].Code)
+	assert.Equal(t, &cText, events[0].FruitText)
+	assert.Equal(t, &fruits1, events[1].Fruits)
+	assert.Equal(t, 2, *events[2].Batteries)
+	assert.Equal(t, 3, *events[2].Ports)
+	assert.Equal(t, 1, events[4].Attempt)
+	assert.Equal(t, &SolutionEvent{Left: 2, Right: 13, Display: "14+91*5=469", Status: 2}, events[4].Solution)
+	assert.Equal(t, &StepResults{true, false, true, false}, events[4].Steps)
+	assert.False(t, *events[4].Correct)
+	assert.True(t, *events[5].Correct)
+	assert.Equal(t, 2, events[6].Attempt)
+
+	// web conns are named without their address
+	assert.Equal(t, "expert-1", events[3].Conn)
+	assert.Equal(t, "expert-1", events[7].Conn)
+	f, _, err = logs.Open(p.LogId(LogEvents))
+	assert.NoError(t, err)
+	raw, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), webServer.RemoteAddr().String())
+}
+
+func TestReadEvents(t *testing.T) {
+	events, err := ReadEvents(strings.NewReader(`{"time":"2023-01-02T03:04:05Z","type":"created","code":"ABCDEF"}
+
+{"time":"2023-01-02T03:04:06Z","type":"closed"}
+`))
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "ABCDEF", events[0].Code)
+
+	_, err = ReadEvents(strings.NewReader("{}\nnot json\n"))
+	assert.ErrorContains(t, err, "invalid event on line 2")
+}
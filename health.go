@@ -0,0 +1,57 @@
+package ktanemod_remote_math_server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// handleHealthz reports the process is alive, it keeps passing while the
+// server shuts down
+func (s *Server) handleHealthz(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the server should receive new clients
+func (s *Server) handleReadyz(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.ready(); err != nil {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("ok\n"))
+}
+
+// ready returns the reason the server isn't ready for new clients
+func (s *Server) ready() error {
+	if s.stopping.Load() || s.rm.Stopping() {
+		return fmt.Errorf("shutting down")
+	}
+	if checker, ok := s.rm.logs.(logStoreChecker); ok {
+		if err := checker.Check(); err != nil {
+			return fmt.Errorf("log store is not writable")
+		}
+	}
+	return nil
+}
+
+// checkWritable creates and removes a temporary file in the directory
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,45 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer_handleReadyz(t *testing.T) {
+	conf := testConfig(t)
+	s := testServer(t, conf)
+	readyz := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	rec := readyz()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	// the log store must be writable
+	fs := s.rm.logs.(*FSLogStore)
+	fs.SetDir(filepath.Join(t.TempDir(), "missing"))
+	rec = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "log store is not writable\n", rec.Body.String())
+	fs.SetDir(conf.LogDir)
+
+	// readiness fails as soon as shutdown starts
+	s.stopping.Store(true)
+	assert.Equal(t, http.StatusServiceUnavailable, readyz().Code)
+	s.stopping.Store(false)
+	s.rm.Close()
+	rec = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "shutting down\n", rec.Body.String())
+
+	// the process is still alive
+	rec = httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
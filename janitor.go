@@ -0,0 +1,123 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// JanitorResult counts the logs changed by CleanLogs
+type JanitorResult struct {
+	Compressed int
+	Deleted    int
+}
+
+// savedPuzzle groups the logs of every kind saved for a puzzle so they are
+// removed together
+type savedPuzzle struct {
+	logs    []LogInfo
+	size    int64
+	modTime time.Time
+}
+
+// CleanLogs applies the retention settings to the saved logs, puzzles older
+// than the max age are removed first then the oldest puzzles are removed until
+// the total size fits, the logs of previous days are compressed last
+func (r *RemoteMath) CleanLogs(now time.Time) (JanitorResult, error) {
+	var result JanitorResult
+	conf := r.conf.Load()
+	logs, err := r.logs.List()
+	if err != nil {
+		return result, err
+	}
+
+	byId := make(map[LogId]*savedPuzzle)
+	var puzzles []*savedPuzzle
+	var total int64
+	for _, l := range logs {
+		id := LogId{Date: l.Date, Code: l.Code}
+		p := byId[id]
+		if p == nil {
+			p = new(savedPuzzle)
+			byId[id] = p
+			puzzles = append(puzzles, p)
+		}
+		p.logs = append(p.logs, l)
+		p.size += l.Size
+		if l.ModTime.After(p.modTime) {
+			p.modTime = l.ModTime
+		}
+		total += l.Size
+	}
+	sort.SliceStable(puzzles, func(i, j int) bool {
+		return puzzles[i].modTime.Before(puzzles[j].modTime)
+	})
+
+	var errs []error
+	keep := puzzles[:0]
+	for _, p := range puzzles {
+		expired := conf.Retention.MaxAge > 0 && now.Sub(p.modTime) > conf.Retention.MaxAge
+		tooBig := conf.Retention.MaxSize > 0 && total > conf.Retention.MaxSize
+		if !expired && !tooBig {
+			keep = append(keep, p)
+			continue
+		}
+		removed := true
+		for _, l := range p.logs {
+			if err := r.logs.Delete(l.LogId); err != nil && !errors.Is(err, ErrLogNotFound) {
+				errs = append(errs, err)
+				removed = false
+				continue
+			}
+			result.Deleted++
+			total -= l.Size
+		}
+		// the puzzle stays searchable while any of its logs are saved
+		if removed {
+			r.index.Remove(p.logs[0].Date, p.logs[0].Code)
+		}
+	}
+
+	compressor, ok := r.logs.(logCompressor)
+	if !conf.Retention.Compress || !ok {
+		return result, errors.Join(errs...)
+	}
+	today := now.Format(time.DateOnly)
+	for _, p := range keep {
+		for _, l := range p.logs {
+			if l.Compressed || l.Date >= today || l.Kind.IsSignature() {
+				continue
+			}
+			if err := compressor.Compress(l.LogId); err != nil && !errors.Is(err, ErrLogNotFound) {
+				errs = append(errs, err)
+				continue
+			}
+			result.Compressed++
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// runJanitor indexes the saved logs and cleans them straight away and then
+// after every interval until stop is closed
+func (s *Server) runJanitor(stop <-chan struct{}) {
+	if err := s.rm.index.Rebuild(s.rm.logs); err != nil {
+		log.Printf("[RemoteMath] Log index error: %s\n", err)
+	}
+	for {
+		result, err := s.rm.CleanLogs(time.Now())
+		if err != nil {
+			log.Printf("[RemoteMath] Log janitor error: %s\n", err)
+		}
+		if result.Compressed > 0 || result.Deleted > 0 {
+			log.Printf("[RemoteMath] Log janitor compressed %d and deleted %d logs\n", result.Compressed, result.Deleted)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.conf.Load().Retention.Interval):
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRemoteMath_CleanLogs(t *testing.T) {
+	conf := testConfig(t)
+	store := NewFSLogStore(conf.LogDir)
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), conf, store)
+	now := time.Now()
+	save := func(date, code string, age time.Duration) {
+		for _, kind := range []LogKind{LogText, LogEvents} {
+			id := LogId{Date: date, Code: code, Kind: kind}
+			assert.NoError(t, store.Save(id, make([]byte, 100)))
+			p, _ := store.path(id)
+			assert.NoError(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+		}
+	}
+	save("2023-01-01", "AAAAAA", 72*time.Hour)
+	save("2023-01-02", "BBBBBB", 48*time.Hour)
+	save("2023-01-03", "CCCCCC", 24*time.Hour)
+	save(now.Format(time.DateOnly), "DDDDDD", time.Minute)
+
+	// nothing happens without a retention policy
+	result, err := r.CleanLogs(now)
+	assert.NoError(t, err)
+	assert.Equal(t, JanitorResult{}, result)
+
+	conf.Retention.MaxAge = 60 * time.Hour
+	conf.Retention.MaxSize = 500
+	conf.Retention.Compress = true
+	result, err = r.CleanLogs(now)
+	assert.NoError(t, err)
+	assert.Equal(t, JanitorResult{Deleted: 4, Compressed: 2}, result)
+
+	logs, err := store.List()
+	assert.NoError(t, err)
+	var codes []string
+	for _, l := range logs {
+		codes = append(codes, l.Code)
+		// only the logs from today are left uncompressed
+		assert.Equal(t, l.Code != "DDDDDD", l.Compressed, l.LogId.String())
+	}
+	assert.Equal(t, []string{"CCCCCC", "CCCCCC", "DDDDDD", "DDDDDD"}, codes)
+}
+
+// failDeleteStore can't delete logs of the kind
+type failDeleteStore struct {
+	*MemoryLogStore
+	kind LogKind
+}
+
+func (f failDeleteStore) Delete(id LogId) error {
+	if id.Kind == f.kind {
+		return errors.New("delete failed")
+	}
+	return f.MemoryLogStore.Delete(id)
+}
+
+func TestRemoteMath_CleanLogs_DeleteFailed(t *testing.T) {
+	conf := testConfig(t)
+	store := failDeleteStore{MemoryLogStore: NewMemoryLogStore(), kind: LogText}
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), conf, store)
+	for _, kind := range []LogKind{LogText, LogEvents} {
+		assert.NoError(t, store.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: kind}, make([]byte, 100)))
+	}
+	r.index.Add(LogSummary{Date: "2023-01-02", Code: "ABCDEF"})
+
+	conf.Retention.MaxSize = 1
+	result, err := r.CleanLogs(time.Now())
+	assert.ErrorContains(t, err, "delete failed")
+	assert.Equal(t, JanitorResult{Deleted: 1}, result)
+
+	// the text log is still saved so the puzzle is kept in the index
+	_, total := r.index.Search(LogFilter{MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, 1, total)
+}
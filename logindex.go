@@ -0,0 +1,302 @@
+package ktanemod_remote_math_server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogsPerPage = 50
+	maxLogsPerPage     = 500
+)
+
+// LogSummary is the searchable information about a saved puzzle
+type LogSummary struct {
+	Date        string    `json:"date"`
+	Code        string    `json:"code"`
+	Created     time.Time `json:"created"`
+	Solved      bool      `json:"solved"`
+	TwitchPlays bool      `json:"twitch_plays"`
+	Training    bool      `json:"training"`
+	Attempts    int       `json:"attempts"`
+}
+
+// SummarizeEvents builds the summary of a puzzle from its event log
+func SummarizeEvents(events []Event) LogSummary {
+	var s LogSummary
+	for _, e := range events {
+		switch e.Type {
+		case EventCreated:
+			s.Code = e.Code
+			s.Created = e.Time
+		case EventTwitchPlays:
+			s.TwitchPlays = true
+		case EventTraining:
+			s.Training = true
+		case EventSolution:
+			s.Attempts++
+		case EventSolved:
+			s.Solved = true
+		}
+	}
+	return s
+}
+
+// LogFilter selects puzzles from the LogIndex, zero values match everything
+type LogFilter struct {
+	From, To    time.Time
+	Solved      *bool
+	TwitchPlays *bool
+	MinAttempts int
+	// MaxAttempts is ignored when it is negative
+	MaxAttempts int
+}
+
+func (f LogFilter) match(s LogSummary) bool {
+	if !f.From.IsZero() && s.Created.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !s.Created.Before(f.To) {
+		return false
+	}
+	if f.Solved != nil && s.Solved != *f.Solved {
+		return false
+	}
+	if f.TwitchPlays != nil && s.TwitchPlays != *f.TwitchPlays {
+		return false
+	}
+	return s.Attempts >= f.MinAttempts && (f.MaxAttempts < 0 || s.Attempts <= f.MaxAttempts)
+}
+
+// LogIndex keeps a summary of every saved puzzle in memory for searching
+type LogIndex struct {
+	mu   sync.RWMutex
+	logs map[LogId]LogSummary
+}
+
+func NewLogIndex() *LogIndex {
+	return &LogIndex{logs: make(map[LogId]LogSummary)}
+}
+
+func indexKey(date, code string) LogId {
+	return LogId{Date: date, Code: code}
+}
+
+// Add replaces the summary of the puzzle
+func (l *LogIndex) Add(s LogSummary) {
+	l.mu.Lock()
+	l.logs[indexKey(s.Date, s.Code)] = s
+	l.mu.Unlock()
+}
+
+// Remove deletes the summary of the puzzle
+func (l *LogIndex) Remove(date, code string) {
+	l.mu.Lock()
+	delete(l.logs, indexKey(date, code))
+	l.mu.Unlock()
+}
+
+// Clear removes every summary
+func (l *LogIndex) Clear() {
+	l.mu.Lock()
+	l.logs = make(map[LogId]LogSummary)
+	l.mu.Unlock()
+}
+
+// Search returns the matching puzzles newest first, skipping offset puzzles and
+// returning at most limit, the total number of matches is also returned
+func (l *LogIndex) Search(f LogFilter, offset, limit int) ([]LogSummary, int) {
+	l.mu.RLock()
+	matches := make([]LogSummary, 0)
+	for _, s := range l.logs {
+		if f.match(s) {
+			matches = append(matches, s)
+		}
+	}
+	l.mu.RUnlock()
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].Created.Equal(matches[j].Created) {
+			return matches[i].Created.After(matches[j].Created)
+		}
+		return matches[i].Code < matches[j].Code
+	})
+	total := len(matches)
+	if offset < 0 || offset > total {
+		// an overflowed offset is past the end
+		offset = total
+	}
+	matches = matches[offset:]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, total
+}
+
+// Rebuild reads the summary of every saved puzzle from the store, puzzles
+// added while rebuilding are kept
+func (l *LogIndex) Rebuild(store LogStore) error {
+	logs, err := store.List()
+	if err != nil {
+		return err
+	}
+	found := make(map[LogId]LogSummary)
+	var errs []error
+	for _, i := range logs {
+		key := indexKey(i.Date, i.Code)
+		if i.Kind != LogEvents {
+			// older logs without events only have the save time
+			if _, ok := found[key]; !ok {
+				found[key] = LogSummary{Date: i.Date, Code: i.Code, Created: i.ModTime}
+			}
+			continue
+		}
+		s, err := summarizeSaved(store, i.LogId)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found[key] = s
+	}
+
+	l.mu.Lock()
+	for k, v := range found {
+		if _, ok := l.logs[k]; !ok {
+			l.logs[k] = v
+		}
+	}
+	l.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// summarizeSaved reads the saved event log and summarizes it
+func summarizeSaved(store LogStore, id LogId) (LogSummary, error) {
+	f, _, err := store.Open(id)
+	if err != nil {
+		return LogSummary{}, err
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		return LogSummary{}, fmt.Errorf("failed to index log '%s': %w", id, err)
+	}
+	s := SummarizeEvents(events)
+	s.Date, s.Code = id.Date, id.Code
+	return s, nil
+}
+
+// indexPuzzle adds the current state of the puzzle to the log index
+func (r *RemoteMath) indexPuzzle(p *Puzzle) {
+	events, err := ReadEvents(bytes.NewReader(p.events.Bytes()))
+	if err != nil {
+		log.Printf("[RemoteMath] Failed to index puzzle %s: %s\n", p.code, err)
+		return
+	}
+	s := SummarizeEvents(events)
+	s.Date, s.Code = p.date.Format(time.DateOnly), p.code
+	r.index.Add(s)
+}
+
+// LogPage is a page of results from the log listing endpoint
+type LogPage struct {
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+	Logs    []LogSummary `json:"logs"`
+}
+
+// parseLogFilter reads the log filter from the query parameters
+func parseLogFilter(req *http.Request) (LogFilter, error) {
+	q := req.URL.Query()
+	f := LogFilter{MaxAttempts: -1}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = parseLogTime(v, false); err != nil {
+			return f, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = parseLogTime(v, true); err != nil {
+			return f, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	parseBool := func(name string) (*bool, error) {
+		v := q.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return &b, nil
+	}
+	if f.Solved, err = parseBool("solved"); err != nil {
+		return f, err
+	}
+	if f.TwitchPlays, err = parseBool("twitch_plays"); err != nil {
+		return f, err
+	}
+	if v := q.Get("min_attempts"); v != "" {
+		if f.MinAttempts, err = strconv.Atoi(v); err != nil || f.MinAttempts < 0 {
+			return f, fmt.Errorf("invalid min_attempts")
+		}
+	}
+	if v := q.Get("max_attempts"); v != "" {
+		if f.MaxAttempts, err = strconv.Atoi(v); err != nil || f.MaxAttempts < 0 {
+			return f, fmt.Errorf("invalid max_attempts")
+		}
+	}
+	return f, nil
+}
+
+// parseLogTime parses an RFC 3339 time or a date, the end of the day is used
+// for the end of a date range
+func parseLogTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a date or RFC 3339 time")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (s *Server) handleAdminLogs(rw http.ResponseWriter, req *http.Request) {
+	f, err := parseLogFilter(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := req.URL.Query()
+	page, perPage := 1, defaultLogsPerPage
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			http.Error(rw, "invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxLogsPerPage {
+			http.Error(rw, fmt.Sprintf("per_page must be between 1 and %d", maxLogsPerPage), http.StatusBadRequest)
+			return
+		}
+	}
+	if page-1 > math.MaxInt/perPage {
+		http.Error(rw, "invalid page", http.StatusBadRequest)
+		return
+	}
+	logs, total := s.rm.index.Search(f, (page-1)*perPage, perPage)
+	writeJson(rw, LogPage{Total: total, Page: page, PerPage: perPage, Logs: logs})
+}
@@ -0,0 +1,159 @@
+package ktanemod_remote_math_server
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testLogSummary(code string, created time.Time, solved, twitch bool, attempts int) LogSummary {
+	return LogSummary{
+		Date:        created.Format(time.DateOnly),
+		Code:        code,
+		Created:     created,
+		Solved:      solved,
+		TwitchPlays: twitch,
+		Attempts:    attempts,
+	}
+}
+
+func TestSummarizeEvents(t *testing.T) {
+	created := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := SummarizeEvents([]Event{
+		{Time: created, Type: EventCreated, Code: "ABCDEF"},
+		{Type: EventTwitchPlays},
+		{Type: EventSolution},
+		{Type: EventSolution},
+		{Type: EventSolved},
+		{Type: EventClosed},
+	})
+	assert.Equal(t, LogSummary{Code: "ABCDEF", Created: created, Solved: true, TwitchPlays: true, Attempts: 2}, s)
+}
+
+func TestLogIndex_Search(t *testing.T) {
+	idx := NewLogIndex()
+	base := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	idx.Add(testLogSummary("AAAAAA", base, true, false, 1))
+	idx.Add(testLogSummary("BBBBBB", base.Add(time.Hour), false, true, 0))
+	idx.Add(testLogSummary("CCCCCC", base.Add(2*time.Hour), true, true, 3))
+	idx.Add(testLogSummary("DDDDDD", base.AddDate(0, 0, 1), false, false, 2))
+
+	codes := func(f LogFilter, offset, limit int) ([]string, int) {
+		logs, total := idx.Search(f, offset, limit)
+		c := make([]string, len(logs))
+		for i, l := range logs {
+			c[i] = l.Code
+		}
+		return c, total
+	}
+	yes, no := true, false
+
+	c, total := codes(LogFilter{MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, []string{"DDDDDD", "CCCCCC", "BBBBBB", "AAAAAA"}, c)
+	assert.Equal(t, 4, total)
+	c, total = codes(LogFilter{MaxAttempts: -1}, 1, 2)
+	assert.Equal(t, []string{"CCCCCC", "BBBBBB"}, c)
+	assert.Equal(t, 4, total)
+	c, _ = codes(LogFilter{MaxAttempts: -1}, 10, 2)
+	assert.Empty(t, c)
+	c, _ = codes(LogFilter{MaxAttempts: -1}, -100, 2)
+	assert.Empty(t, c)
+
+	c, _ = codes(LogFilter{From: base.Add(30 * time.Minute), To: base.Add(2 * time.Hour), MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, []string{"BBBBBB"}, c)
+	c, _ = codes(LogFilter{Solved: &yes, MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, []string{"CCCCCC", "AAAAAA"}, c)
+	c, _ = codes(LogFilter{TwitchPlays: &no, MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, []string{"DDDDDD", "AAAAAA"}, c)
+	c, _ = codes(LogFilter{MinAttempts: 1, MaxAttempts: 2}, 0, 10)
+	assert.Equal(t, []string{"DDDDDD", "AAAAAA"}, c)
+
+	idx.Remove(base.Format(time.DateOnly), "AAAAAA")
+	c, _ = codes(LogFilter{Solved: &yes, MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, []string{"CCCCCC"}, c)
+}
+
+func TestLogIndex_Rebuild(t *testing.T) {
+	for name, logs := range testLogStores(t) {
+		t.Run(name, func(t *testing.T) {
+			r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), logs)
+			modServer, _ := testConnPair(t)
+			p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+			p.RecvMod("PuzzleTwitchPlaysMode::42")
+			assert.NoError(t, r.SaveLog(p))
+			old := LogId{Date: "2022-12-25", Code: "OLDLOG", Kind: LogText}
+			assert.NoError(t, logs.Save(old, []byte("old\n")))
+
+			idx := NewLogIndex()
+			assert.NoError(t, idx.Rebuild(logs))
+			found, total := idx.Search(LogFilter{MaxAttempts: -1}, 0, 10)
+			assert.Equal(t, 2, total)
+			byCode := make(map[string]LogSummary)
+			for _, s := range found {
+				byCode[s.Code] = s
+			}
+			assert.True(t, byCode[p.code].TwitchPlays)
+			assert.Equal(t, p.date.Format(time.DateOnly), byCode[p.code].Date)
+			// logs without events are still listed
+			assert.Equal(t, "2022-12-25", byCode["OLDLOG"].Date)
+
+			// SaveLog keeps the index of the server up to date
+			found, _ = r.index.Search(LogFilter{MaxAttempts: -1}, 0, 10)
+			assert.Len(t, found, 1)
+			assert.Equal(t, p.code, found[0].Code)
+		})
+	}
+}
+
+func TestRemoteMath_SetConfig_LogDir(t *testing.T) {
+	conf := testConfig(t)
+	logs := NewFSLogStore(conf.LogDir)
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), conf, logs)
+	r.index.Add(LogSummary{Date: "2023-01-02", Code: "OLDDIR"})
+
+	newConf := testConfig(t)
+	assert.NoError(t, NewFSLogStore(newConf.LogDir).Save(LogId{Date: "2023-01-03", Code: "NEWDIR", Kind: LogText}, []byte("new\n")))
+
+	// puzzles are listed from the new log directory only
+	r.SetConfig(newConf)
+	found, total := r.index.Search(LogFilter{MaxAttempts: -1}, 0, 10)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "NEWDIR", found[0].Code)
+}
+
+func TestServer_handleAdminLogs(t *testing.T) {
+	conf := testConfig(t)
+	conf.Admin.Token = testAdminToken
+	s := testServer(t, conf)
+	base := time.Date(2023, 1, 2, 12, 0, 0, 0, time.Local)
+	for i, code := range []string{"AAAAAA", "BBBBBB", "CCCCCC"} {
+		s.rm.index.Add(testLogSummary(code, base.Add(time.Duration(i)*time.Hour), i != 1, false, i))
+	}
+	s.rm.index.Add(testLogSummary("DDDDDD", base.AddDate(0, 0, 1), true, false, 1))
+
+	rec := testAdminRequest(s, http.MethodGet, "/api/admin/logs?from=2023-01-02&to=2023-01-02&solved=true&per_page=1&page=2", s.handleAdminLogs)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var page LogPage
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, 2, page.Page)
+	assert.Equal(t, 1, page.PerPage)
+	assert.Len(t, page.Logs, 1)
+	assert.Equal(t, "AAAAAA", page.Logs[0].Code)
+
+	rec = testAdminRequest(s, http.MethodGet, "/api/admin/logs?from="+base.Add(30*time.Minute).Format(time.RFC3339)+"&min_attempts=1&max_attempts=1", s.handleAdminLogs)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	page = LogPage{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, defaultLogsPerPage, page.PerPage)
+
+	for _, q := range []string{"from=yesterday", "solved=maybe", "min_attempts=-1", "page=0", "per_page=501", "page=9223372036854775807", "page=92233720368547760&per_page=100"} {
+		rec = testAdminRequest(s, http.MethodGet, "/api/admin/logs?"+q, s.handleAdminLogs)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+	}
+}
@@ -0,0 +1,416 @@
+package ktanemod_remote_math_server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLogNotFound is returned when a log doesn't exist in the LogStore
+var ErrLogNotFound = errors.New("log not found")
+
+// ErrLogNotCompressed is returned by OpenCompressed when the log is saved
+// without compression
+var ErrLogNotCompressed = errors.New("log not compressed")
+
+// LogKind is the format of a saved log, it is also used as the file extension
+type LogKind string
+
+const (
+	// LogText is the human-readable puzzle log
+	LogText LogKind = "log"
+	// LogEvents is the structured event log in JSON Lines format
+	LogEvents LogKind = "jsonl"
+	// LogTextSignature and LogEventsSignature hold the signatures of the logs
+	LogTextSignature   LogKind = "log.sig"
+	LogEventsSignature LogKind = "jsonl.sig"
+)
+
+// IsSignature returns true if the kind holds the signature of another log
+func (k LogKind) IsSignature() bool {
+	return strings.HasSuffix(string(k), ".sig")
+}
+
+// LogId identifies a puzzle log by the date it was created and the puzzle code
+type LogId struct {
+	Date string  `json:"date"`
+	Code string  `json:"code"`
+	Kind LogKind `json:"kind"`
+}
+
+// Valid returns true if the date, code and kind are in the expected format
+func (l LogId) Valid() bool {
+	switch l.Kind {
+	case LogText, LogEvents, LogTextSignature, LogEventsSignature:
+		return regLogDate.MatchString(l.Date) && regLogCode.MatchString(l.Code)
+	}
+	return false
+}
+
+// SignatureId returns the id of the signature saved for the log
+func (l LogId) SignatureId() LogId {
+	l.Kind += ".sig"
+	return l
+}
+
+func (l LogId) String() string {
+	return l.Date + "/" + l.Code + "." + string(l.Kind)
+}
+
+// LogInfo describes a saved log
+type LogInfo struct {
+	LogId
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Compressed is true if the log is saved with gzip, Size is then the
+	// compressed size
+	Compressed bool `json:"compressed"`
+}
+
+// LogStore saves finished puzzle logs and reads them back for the /log endpoint
+type LogStore interface {
+	// Save replaces the log with the data
+	Save(id LogId, data []byte) error
+	// Open returns the log contents, the reader must be closed by the caller
+	Open(id LogId) (io.ReadSeekCloser, LogInfo, error)
+	// List returns every saved log sorted by date, code and kind
+	List() ([]LogInfo, error)
+	// Delete removes the log
+	Delete(id LogId) error
+}
+
+// logCompressor is implemented by stores which can gzip old logs, Open still
+// returns the uncompressed contents
+type logCompressor interface {
+	// Compress replaces the saved log with a gzip compressed copy
+	Compress(id LogId) error
+	// OpenCompressed returns the gzip compressed contents of the log
+	OpenCompressed(id LogId) (io.ReadSeekCloser, LogInfo, error)
+}
+
+// logStoreChecker is implemented by stores which can report if saving logs
+// is currently possible
+type logStoreChecker interface {
+	Check() error
+}
+
+// OpenLogStore creates the LogStore selected in the config
+func OpenLogStore(conf *Config) (LogStore, error) {
+	switch conf.LogStore.Type {
+	case "fs":
+		return NewFSLogStore(conf.LogDir), nil
+	case "memory":
+		return NewMemoryLogStore(), nil
+	case "sqlite":
+		return NewSQLiteLogStore(conf.LogStore.SQLitePath)
+	}
+	return nil, fmt.Errorf("unknown log store type '%s'", conf.LogStore.Type)
+}
+
+func sortLogInfo(logs []LogInfo) {
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].Date != logs[j].Date {
+			return logs[i].Date < logs[j].Date
+		}
+		if logs[i].Code != logs[j].Code {
+			return logs[i].Code < logs[j].Code
+		}
+		return logs[i].Kind < logs[j].Kind
+	})
+}
+
+// FSLogStore saves logs as files named <dir>/<date>/<code>.<kind>, compressed
+// logs have an extra .gz extension
+type FSLogStore struct {
+	dir *atomic.Pointer[string]
+}
+
+func NewFSLogStore(dir string) *FSLogStore {
+	f := &FSLogStore{dir: new(atomic.Pointer[string])}
+	f.SetDir(dir)
+	return f
+}
+
+// SetDir changes the directory used for logs saved and opened afterwards
+func (f *FSLogStore) SetDir(dir string) {
+	f.dir.Store(&dir)
+}
+
+func (f *FSLogStore) path(id LogId) (string, error) {
+	if !id.Valid() {
+		return "", ErrLogNotFound
+	}
+	return filepath.Join(*f.dir.Load(), id.Date, id.Code+"."+string(id.Kind)), nil
+}
+
+func (f *FSLogStore) Save(id LogId, data []byte) error {
+	p, err := f.path(id)
+	if err != nil {
+		return fmt.Errorf("invalid log id '%s'", id)
+	}
+	logPath := filepath.Dir(p)
+	err = os.Mkdir(logPath, os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create log directory '%s': %w", logPath, err)
+	}
+	err = os.WriteFile(p, data, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to write log file '%s': %w", p, err)
+	}
+	// remove the older compressed copy
+	_ = os.Remove(p + ".gz")
+	return nil
+}
+
+func (f *FSLogStore) Open(id LogId) (io.ReadSeekCloser, LogInfo, error) {
+	p, err := f.path(id)
+	if err != nil {
+		return nil, LogInfo{}, err
+	}
+	file, info, err := openLogFile(id, p)
+	if !errors.Is(err, ErrLogNotFound) {
+		return file, info, err
+	}
+
+	// read the compressed copy instead
+	file, info, err = openLogFile(id, p+".gz")
+	if err != nil {
+		return nil, LogInfo{}, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, LogInfo{}, fmt.Errorf("failed to read compressed log '%s': %w", id, err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, LogInfo{}, fmt.Errorf("failed to read compressed log '%s': %w", id, err)
+	}
+	info.Size = int64(len(data))
+	info.Compressed = false
+	return nopSeekCloser{bytes.NewReader(data)}, info, nil
+}
+
+func (f *FSLogStore) OpenCompressed(id LogId) (io.ReadSeekCloser, LogInfo, error) {
+	p, err := f.path(id)
+	if err != nil {
+		return nil, LogInfo{}, err
+	}
+	file, info, err := openLogFile(id, p+".gz")
+	if errors.Is(err, ErrLogNotFound) {
+		if _, err := os.Stat(p); err == nil {
+			return nil, LogInfo{}, ErrLogNotCompressed
+		}
+	}
+	return file, info, err
+}
+
+// openLogFile opens the file and returns the log info from the file stat
+func openLogFile(id LogId, p string) (*os.File, LogInfo, error) {
+	file, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, LogInfo{}, ErrLogNotFound
+	} else if err != nil {
+		return nil, LogInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, LogInfo{}, err
+	}
+	info := LogInfo{LogId: id, Size: stat.Size(), ModTime: stat.ModTime(), Compressed: strings.HasSuffix(p, ".gz")}
+	return file, info, nil
+}
+
+// Compress writes the gzip compressed copy of the log then removes the original
+func (f *FSLogStore) Compress(id LogId) error {
+	p, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrLogNotFound
+	} else if err != nil {
+		return err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if err := writeGzip(p+".gz", data, stat.ModTime()); err != nil {
+		return fmt.Errorf("failed to compress log '%s': %w", id, err)
+	}
+	return os.Remove(p)
+}
+
+// writeGzip writes the compressed data to a temporary file then renames it,
+// so a partial copy is never served
+func writeGzip(path string, data []byte, modTime time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".compress-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	gz.ModTime = modTime
+	if _, err := gz.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// keep the original modified time for retention and caching
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FSLogStore) List() ([]LogInfo, error) {
+	dir := *f.dir.Load()
+	dates, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		// no logs have been saved yet
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var logs []LogInfo
+	for _, d := range dates {
+		if !d.IsDir() || !regLogDate.MatchString(d.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range files {
+			name, compressed := strings.CutSuffix(i.Name(), ".gz")
+			code, kind, _ := strings.Cut(name, ".")
+			id := LogId{Date: d.Name(), Code: code, Kind: LogKind(kind)}
+			if i.IsDir() || !id.Valid() {
+				continue
+			}
+			info, err := i.Info()
+			if err != nil {
+				// the log was deleted while listing
+				continue
+			}
+			logs = append(logs, LogInfo{
+				LogId:      id,
+				Size:       info.Size(),
+				ModTime:    info.ModTime(),
+				Compressed: compressed,
+			})
+		}
+	}
+	sortLogInfo(logs)
+	return logs, nil
+}
+
+// Delete removes the log and its compressed copy, the date directory is also
+// removed once it is empty
+func (f *FSLogStore) Delete(id LogId) error {
+	p, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	errGz := os.Remove(p + ".gz")
+	if errors.Is(err, os.ErrNotExist) && errors.Is(errGz, os.ErrNotExist) {
+		return ErrLogNotFound
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if errGz != nil && !errors.Is(errGz, os.ErrNotExist) {
+		return errGz
+	}
+	// fails if there are other logs for the date
+	_ = os.Remove(filepath.Dir(p))
+	return nil
+}
+
+// Check creates and removes a temporary file in the log directory
+func (f *FSLogStore) Check() error {
+	return checkWritable(*f.dir.Load())
+}
+
+// MemoryLogStore keeps logs in memory, it is mostly useful for tests
+type MemoryLogStore struct {
+	mu   sync.RWMutex
+	logs map[LogId]memoryLog
+}
+
+type memoryLog struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryLogStore() *MemoryLogStore {
+	return &MemoryLogStore{logs: make(map[LogId]memoryLog)}
+}
+
+func (m *MemoryLogStore) Save(id LogId, data []byte) error {
+	if !id.Valid() {
+		return fmt.Errorf("invalid log id '%s'", id)
+	}
+	m.mu.Lock()
+	m.logs[id] = memoryLog{data: bytes.Clone(data), modTime: time.Now()}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryLogStore) Open(id LogId) (io.ReadSeekCloser, LogInfo, error) {
+	m.mu.RLock()
+	l, ok := m.logs[id]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, LogInfo{}, ErrLogNotFound
+	}
+	return nopSeekCloser{bytes.NewReader(l.data)}, LogInfo{LogId: id, Size: int64(len(l.data)), ModTime: l.modTime}, nil
+}
+
+func (m *MemoryLogStore) List() ([]LogInfo, error) {
+	m.mu.RLock()
+	logs := make([]LogInfo, 0, len(m.logs))
+	for id, l := range m.logs {
+		logs = append(logs, LogInfo{LogId: id, Size: int64(len(l.data)), ModTime: l.modTime})
+	}
+	m.mu.RUnlock()
+	sortLogInfo(logs)
+	return logs, nil
+}
+
+func (m *MemoryLogStore) Delete(id LogId) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.logs[id]; !ok {
+		return ErrLogNotFound
+	}
+	delete(m.logs, id)
+	return nil
+}
+
+// nopSeekCloser adds a Close method which does nothing
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
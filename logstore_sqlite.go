@@ -0,0 +1,104 @@
+package ktanemod_remote_math_server
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	_ "modernc.org/sqlite"
+	"time"
+)
+
+const sqliteLogSchema = `CREATE TABLE IF NOT EXISTS logs (
+	date     TEXT    NOT NULL,
+	code     TEXT    NOT NULL,
+	kind     TEXT    NOT NULL,
+	data     BLOB    NOT NULL,
+	mod_time INTEGER NOT NULL,
+	PRIMARY KEY (date, code, kind)
+)`
+
+// SQLiteLogStore saves logs in an embedded SQLite database
+type SQLiteLogStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteLogStore opens the database file and creates the logs table
+func NewSQLiteLogStore(path string) (*SQLiteLogStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log database '%s': %w", path, err)
+	}
+	// sqlite only allows a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteLogSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create log database '%s': %w", path, err)
+	}
+	return &SQLiteLogStore{db: db}, nil
+}
+
+func (s *SQLiteLogStore) Save(id LogId, data []byte) error {
+	if !id.Valid() {
+		return fmt.Errorf("invalid log id '%s'", id)
+	}
+	_, err := s.db.Exec(`INSERT INTO logs (date, code, kind, data, mod_time) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (date, code, kind) DO UPDATE SET data = excluded.data, mod_time = excluded.mod_time`,
+		id.Date, id.Code, id.Kind, data, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save log '%s': %w", id, err)
+	}
+	return nil
+}
+
+func (s *SQLiteLogStore) Open(id LogId) (io.ReadSeekCloser, LogInfo, error) {
+	var data []byte
+	var modTime int64
+	err := s.db.QueryRow(`SELECT data, mod_time FROM logs WHERE date = ? AND code = ? AND kind = ?`, id.Date, id.Code, id.Kind).Scan(&data, &modTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, LogInfo{}, ErrLogNotFound
+	} else if err != nil {
+		return nil, LogInfo{}, err
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, LogInfo{LogId: id, Size: int64(len(data)), ModTime: time.UnixMilli(modTime)}, nil
+}
+
+func (s *SQLiteLogStore) List() ([]LogInfo, error) {
+	rows, err := s.db.Query(`SELECT date, code, kind, length(data), mod_time FROM logs ORDER BY date, code, kind`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var logs []LogInfo
+	for rows.Next() {
+		var l LogInfo
+		var modTime int64
+		if err := rows.Scan(&l.Date, &l.Code, &l.Kind, &l.Size, &modTime); err != nil {
+			return nil, err
+		}
+		l.ModTime = time.UnixMilli(modTime)
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+func (s *SQLiteLogStore) Delete(id LogId) error {
+	res, err := s.db.Exec(`DELETE FROM logs WHERE date = ? AND code = ? AND kind = ?`, id.Date, id.Code, id.Kind)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrLogNotFound
+	}
+	return nil
+}
+
+// Check makes sure the database can still be reached
+func (s *SQLiteLogStore) Check() error {
+	return s.db.Ping()
+}
+
+func (s *SQLiteLogStore) Close() error {
+	return s.db.Close()
+}
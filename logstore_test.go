@@ -0,0 +1,160 @@
+package ktanemod_remote_math_server
+
+import (
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func testLogStores(t *testing.T) map[string]LogStore {
+	sqlite, err := NewSQLiteLogStore(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlite.Close() })
+	return map[string]LogStore{
+		"fs":     NewFSLogStore(t.TempDir()),
+		"memory": NewMemoryLogStore(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestLogStore(t *testing.T) {
+	for name, store := range testLogStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a := LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}
+			b := LogId{Date: "2023-01-01", Code: "GHIJKL", Kind: LogText}
+			c := LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogEvents}
+			assert.NoError(t, store.Save(a, []byte("first")))
+			assert.NoError(t, store.Save(a, []byte("second")))
+			assert.NoError(t, store.Save(b, []byte("other")))
+			assert.NoError(t, store.Save(c, []byte("{}\n")))
+			assert.Error(t, store.Save(LogId{Date: "../..", Code: "ABCDEF", Kind: LogText}, nil))
+			assert.Error(t, store.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: "exe"}, nil))
+
+			f, info, err := store.Open(a)
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(f)
+			assert.NoError(t, f.Close())
+			assert.Equal(t, "second", string(data))
+			assert.Equal(t, int64(6), info.Size)
+			assert.False(t, info.ModTime.IsZero())
+
+			logs, err := store.List()
+			assert.NoError(t, err)
+			if assert.Len(t, logs, 3) {
+				assert.Equal(t, b, logs[0].LogId)
+				assert.Equal(t, c, logs[1].LogId)
+				assert.Equal(t, a, logs[2].LogId)
+				assert.Equal(t, int64(5), logs[0].Size)
+			}
+
+			assert.NoError(t, store.Delete(a))
+			assert.ErrorIs(t, store.Delete(a), ErrLogNotFound)
+			_, _, err = store.Open(a)
+			assert.ErrorIs(t, err, ErrLogNotFound)
+		})
+	}
+}
+
+func TestServer_handleLog(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	s.rm.logs = NewMemoryLogStore()
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}, []byte("Module ID: ABCDEF\n")))
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogEvents}, []byte("{\"type\":\"created\"}\n")))
+
+	rec := httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=abcdef", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Module ID: ABCDEF\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=ABCDEF&format=json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"type\":\"created\"}\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=ABCDEF&format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	for _, target := range []string{"/log?date=2023-01-03&code=ABCDEF", "/log?date=../..&code=ABCDEF", "/log"} {
+		rec = httptest.NewRecorder()
+		s.handleLog(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, target)
+	}
+}
+
+func TestFSLogStore_Compress(t *testing.T) {
+	store := NewFSLogStore(t.TempDir())
+	id := LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}
+	_, _, err := store.OpenCompressed(id)
+	assert.ErrorIs(t, err, ErrLogNotFound)
+	assert.NoError(t, store.Save(id, []byte("Module ID: ABCDEF\n")))
+	_, _, err = store.OpenCompressed(id)
+	assert.ErrorIs(t, err, ErrLogNotCompressed)
+	assert.NoError(t, store.Compress(id))
+
+	// the compressed log is read transparently
+	f, info, err := store.Open(id)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(f)
+	assert.Equal(t, "Module ID: ABCDEF\n", string(data))
+	assert.Equal(t, int64(18), info.Size)
+
+	f, info, err = store.OpenCompressed(id)
+	assert.NoError(t, err)
+	assert.True(t, info.Compressed)
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(gz)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, "Module ID: ABCDEF\n", string(data))
+
+	logs, err := store.List()
+	assert.NoError(t, err)
+	if assert.Len(t, logs, 1) {
+		assert.True(t, logs[0].Compressed)
+	}
+
+	// saving again replaces the compressed copy
+	assert.NoError(t, store.Save(id, []byte("new")))
+	logs, _ = store.List()
+	if assert.Len(t, logs, 1) {
+		assert.False(t, logs[0].Compressed)
+	}
+	assert.NoError(t, store.Delete(id))
+	assert.NoDirExists(t, filepath.Join(*store.dir.Load(), id.Date))
+}
+
+func TestServer_handleLog_Gzip(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	id := LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}
+	assert.NoError(t, s.rm.logs.Save(id, []byte("Module ID: ABCDEF\n")))
+	assert.NoError(t, s.rm.logs.(*FSLogStore).Compress(id))
+
+	req := httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=ABCDEF", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	rec := httptest.NewRecorder()
+	s.handleLog(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	gz, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(gz)
+	assert.Equal(t, "Module ID: ABCDEF\n", string(data))
+
+	// clients without gzip get the plain log
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, req)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	assert.Equal(t, "Module ID: ABCDEF\n", rec.Body.String())
+}
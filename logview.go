@@ -0,0 +1,281 @@
+package ktanemod_remote_math_server
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var logTemplate = template.Must(template.New("log.html").Funcs(template.FuncMap{
+	"elapsed": func(d time.Duration) string {
+		return d.Truncate(time.Second).String()
+	},
+}).ParseFS(templateFiles, "templates/log.html"))
+
+// LogView is the puzzle log rendered by the HTML log viewer
+type LogView struct {
+	Id          LogId
+	Created     time.Time
+	TwitchPlays bool
+	Training    bool
+	Solved      bool
+	RuleSet     string
+	// UnknownRuleSet is true when the rule set is no longer registered
+	UnknownRuleSet bool
+	Fruits         []FruitRow
+	Batteries      *int
+	Ports          *int
+	Attempts       []AttemptView
+	Timeline       []TimelineEntry
+	// Text is the plain text log, only shown for logs saved without events
+	Text string
+}
+
+// FruitRow is one of the four fruits on the module
+type FruitRow struct {
+	Position string
+	Image    string
+	Text     string
+	Number   int
+	// Invalid is true when the saved fruit is out of range so it has no number
+	Invalid bool
+}
+
+// AttemptView compares the given answers of a solution attempt with the
+// correct answers
+type AttemptView struct {
+	Attempt int
+	Time    time.Time
+	Correct bool
+	Steps   []StepView
+}
+
+// StepView is a single step of a solution attempt
+type StepView struct {
+	Step     int
+	Expected string
+	Given    string
+	Correct  bool
+}
+
+// TimelineEntry is a single event shown in the timeline
+type TimelineEntry struct {
+	Time    time.Time
+	Elapsed time.Duration
+	Text    string
+}
+
+// fruitPositions matches the fruit indexes of the module to their position,
+// the image and text indexes of each row
+var fruitPositions = []struct {
+	name        string
+	image, text int
+}{
+	{"Defuser Top", 0, 2},
+	{"Defuser Right", 1, 3},
+	{"Expert Left", 4, 6},
+	{"Expert Right", 5, 7},
+}
+
+// NewLogView builds the log view from the events of a puzzle
+func NewLogView(id LogId, events []Event) LogView {
+	v := LogView{Id: id, RuleSet: DefaultRuleSetId}
+	p := NewPuzzle(nil, false)
+	p.log.SetOutput(io.Discard)
+	var fruits, details bool
+	for _, e := range events {
+		if v.Created.IsZero() {
+			v.Created = e.Time
+		}
+		switch e.Type {
+		case EventCreated:
+			v.Created = e.Time
+			if e.FruitText != nil {
+				p.cText = *e.FruitText
+			}
+		case EventTwitchPlays:
+			v.TwitchPlays = true
+		case EventTraining:
+			v.Training = true
+		case EventFruits:
+			if e.Fruits == nil {
+				break
+			}
+			p.fruits = *e.Fruits
+			fruits = true
+		case EventRuleSet:
+			v.RuleSet = e.RuleSet
+			if rules := GetRuleSet(e.RuleSet); rules != nil {
+				p.rules = rules
+			} else {
+				// the numbers and correct answers can't be shown without the rules
+				v.UnknownRuleSet = true
+			}
+		case EventBombDetails:
+			if e.Batteries == nil || e.Ports == nil {
+				break
+			}
+			p.batteries, p.ports = *e.Batteries, *e.Ports
+			v.Batteries, v.Ports = e.Batteries, e.Ports
+			details = true
+		case EventSolution:
+			if e.Solution == nil || e.Steps == nil {
+				break
+			}
+			a := AttemptView{Attempt: e.Attempt, Time: e.Time, Correct: e.Steps.Correct()}
+			var expected [4]string
+			// edited logs can have fruits which can't be looked up
+			if fruits && details && !v.UnknownRuleSet && validFruits(p.fruits[:]) && validFruits(p.cText[:]) {
+				answers := p.Answers()
+				expected = [4]string{
+					strconv.Itoa(answers.Left),
+					strconv.Itoa(answers.Right),
+					answers.Display,
+					fmt.Sprintf("%d or %d", answers.Status[0], answers.Status[1]),
+				}
+			}
+			given := [4]string{
+				strconv.Itoa(e.Solution.Left),
+				strconv.Itoa(e.Solution.Right),
+				e.Solution.Display,
+				strconv.Itoa(e.Solution.Status),
+			}
+			for i := range given {
+				a.Steps = append(a.Steps, StepView{Step: i + 1, Expected: expected[i], Given: given[i], Correct: e.Steps[i]})
+			}
+			v.Attempts = append(v.Attempts, a)
+		case EventSolved:
+			v.Solved = true
+		}
+		v.Timeline = append(v.Timeline, TimelineEntry{Time: e.Time, Elapsed: e.Time.Sub(v.Created), Text: describeEvent(e)})
+	}
+
+	// the rule set can be selected after the fruits are sent
+	if fruits {
+		for _, i := range fruitPositions {
+			image, text := p.fruits[i.image], p.fruits[i.text]
+			row := FruitRow{Position: i.name, Image: fruitName(image), Text: fruitName(text)}
+			if !validFruits([]int{image, text}) {
+				row.Invalid = true
+			} else if !v.UnknownRuleSet {
+				row.Number = p.RuleSet().FruitNumber(image, text)
+			}
+			v.Fruits = append(v.Fruits, row)
+		}
+	}
+	return v
+}
+
+// fruitName returns the name of the fruit, unknown is used for fruits which are
+// out of range
+func fruitName(i int) string {
+	if !validFruits([]int{i}) {
+		return "unknown"
+	}
+	return fruitNames[i]
+}
+
+// describeEvent returns a short sentence about the event for the timeline
+func describeEvent(e Event) string {
+	switch e.Type {
+	case EventCreated:
+		return fmt.Sprintf("Puzzle %s created by a %s module", e.Code, e.Protocol)
+	case EventModuleDetached:
+		return "Module disconnected"
+	case EventModuleResumed:
+		return "Module resumed"
+	case EventTwitchPlays:
+		return "Twitch Plays mode enabled, module ID " + e.TwitchId
+	case EventTraining:
+		return "Training mode enabled"
+	case EventFruits:
+		return "Fruits received"
+	case EventRuleSet:
+		return "Rule set " + e.RuleSet + " selected"
+	case EventBombDetails:
+		if e.Batteries == nil || e.Ports == nil {
+			break
+		}
+		return fmt.Sprintf("Bomb has %d batteries and %d ports", *e.Batteries, *e.Ports)
+	case EventWebConnected:
+		return fmt.Sprintf("Expert %s connected using %s", e.Conn, e.Protocol)
+	case EventWebRejoined:
+		return fmt.Sprintf("Expert %s rejoined", e.Conn)
+	case EventWebDisconnected:
+		return fmt.Sprintf("Expert %s disconnected", e.Conn)
+	case EventTwitchActivated:
+		return fmt.Sprintf("Expert %s activated Twitch Plays code %s", e.Conn, e.TwitchCode)
+	case EventSolution:
+		if e.Correct != nil && *e.Correct {
+			return fmt.Sprintf("Attempt %d was correct", e.Attempt)
+		}
+		return fmt.Sprintf("Attempt %d was incorrect", e.Attempt)
+	case EventSolved:
+		return "Puzzle solved"
+	case EventNotice:
+		return "Notice: " + e.Message
+	case EventClosed:
+		return "Puzzle closed"
+	}
+	return e.Type
+}
+
+// handleLogView renders the puzzle log as an HTML page, logs saved without
+// events show the plain text log instead
+func (s *Server) handleLogView(rw http.ResponseWriter, id LogId) {
+	var v LogView
+	f, _, err := s.rm.logs.Open(LogId{Date: id.Date, Code: id.Code, Kind: LogEvents})
+	if err == nil {
+		defer f.Close()
+		events, err := ReadEvents(f)
+		if err != nil {
+			log.Printf("[RemoteMath] Failed to read log %s: %s\n", id, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		v = NewLogView(id, events)
+	} else if errors.Is(err, ErrLogNotFound) {
+		v, err = s.textLogView(id)
+	}
+	if errors.Is(err, ErrLogNotFound) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("[RemoteMath] Failed to open log %s: %s\n", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := logTemplate.Execute(&buf, v); err != nil {
+		log.Printf("[RemoteMath] Failed to render log %s: %s\n", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(rw)
+}
+
+// textLogView reads the plain text log into an otherwise empty view
+func (s *Server) textLogView(id LogId) (LogView, error) {
+	f, _, err := s.rm.logs.Open(LogId{Date: id.Date, Code: id.Code, Kind: LogText})
+	if err != nil {
+		return LogView{}, err
+	}
+	defer f.Close()
+	text, err := io.ReadAll(f)
+	if err != nil {
+		return LogView{}, err
+	}
+	return LogView{Id: id, Text: string(text)}, nil
+}
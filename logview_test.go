@@ -0,0 +1,93 @@
+package ktanemod_remote_math_server
+
+import (
+	"fmt"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogView(t *testing.T) {
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), NewMemoryLogStore())
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.RecvMod("PuzzleFruits::1::3::4::1::0::3::5::2")
+	p.RecvMod("BombDetails::2::3")
+	webServer, _ := testConnPair(t)
+	r.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, protocol.Features{Version: 1})
+	p.RecvWebConn("PuzzleSolution::2::13::14+91*5=469::2")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	r.ClosePuzzle(p)
+
+	events, err := ReadEvents(strings.NewReader(p.events.String()))
+	assert.NoError(t, err)
+	status := fmt.Sprintf("%d or %d", p.cText[0], p.cText[1])
+	v := NewLogView(p.LogId(LogEvents), events)
+	assert.False(t, v.TwitchPlays)
+	assert.Equal(t, events[0].Time, v.Created)
+	assert.Equal(t, FruitRow{Position: "Defuser Top", Image: "Melon", Text: "Pineapple", Number: 91}, v.Fruits[0])
+	assert.Len(t, v.Fruits, 4)
+	assert.Equal(t, 2, *v.Batteries)
+	assert.Equal(t, 3, *v.Ports)
+
+	assert.Len(t, v.Attempts, 2)
+	assert.False(t, v.Attempts[0].Correct)
+	assert.Equal(t, []StepView{
+		{Step: 1, Expected: "2", Given: "2", Correct: true},
+		{Step: 2, Expected: "12", Given: "13", Correct: false},
+		{Step: 3, Expected: "14+91*5=469", Given: "14+91*5=469", Correct: true},
+		{Step: 4, Expected: status, Given: "2", Correct: p.cText[0] == 2 || p.cText[1] == 2},
+	}, v.Attempts[0].Steps)
+	assert.Equal(t, p.cText[0] == 0 || p.cText[1] == 0, v.Attempts[1].Correct)
+
+	assert.Len(t, v.Timeline, len(events))
+	assert.Equal(t, "Attempt 1 was incorrect", v.Timeline[4].Text)
+	assert.Equal(t, "Puzzle closed", v.Timeline[len(v.Timeline)-1].Text)
+}
+
+func TestServer_handleLogView(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	s.rm.logs = NewMemoryLogStore()
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}, []byte("Module ID: ABCDEF\n")))
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogEvents}, []byte(`{"time":"2023-01-02T03:04:05Z","type":"created","code":"ABCDEF","fruit_text":[0,1]}
+{"time":"2023-01-02T03:04:06Z","type":"notice","message":"<b>restart</b>"}
+`)))
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "OLDLOG", Kind: LogText}, []byte("Module ID: <OLDLOG>\n")))
+
+	rec := httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=abcdef&format=html", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "<h1>Remote Math ABCDEF</h1>")
+	assert.Contains(t, body, `href="/log?date=2023-01-02&amp;code=ABCDEF"`)
+	assert.Contains(t, body, "Notice: &lt;b&gt;restart&lt;/b&gt;")
+	assert.Contains(t, body, "No solutions were attempted.")
+
+	// logs without events show the plain text log
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=OLDLOG&format=html", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<pre>Module ID: &lt;OLDLOG&gt;\n</pre>")
+
+	// edited logs with fruits out of range show unknown fruits
+	assert.NoError(t, s.rm.logs.Save(LogId{Date: "2023-01-02", Code: "BADFRU", Kind: LogEvents}, []byte(`{"time":"2023-01-02T03:04:05Z","type":"created","code":"BADFRU","fruit_text":[0,9]}
+{"time":"2023-01-02T03:04:06Z","type":"fruits","fruits":[9,3,4,1,0,3,5,-1]}
+{"time":"2023-01-02T03:04:07Z","type":"bomb_details","batteries":2,"ports":3}
+{"time":"2023-01-02T03:04:08Z","type":"solution","attempt":1,"solution":{"left":2,"right":12,"display":"14+91*5=469","status":0},"steps":[true,true,true,true],"correct":true}
+`)))
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-02&code=BADFRU&format=html", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<tr><td>Defuser Top</td><td>unknown</td><td>Pineapple</td><td class="number">unknown</td></tr>`)
+	assert.Contains(t, rec.Body.String(), `<tr><td>Expert Right</td><td>Pear</td><td>unknown</td><td class="number">unknown</td></tr>`)
+	assert.Contains(t, rec.Body.String(), `<tr><td>Defuser Right</td><td>Pear</td><td>Melon</td><td class="number">5</td></tr>`)
+
+	rec = httptest.NewRecorder()
+	s.handleLog(rec, httptest.NewRequest(http.MethodGet, "/log?date=2023-01-03&code=ABCDEF&format=html", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
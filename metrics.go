@@ -0,0 +1,107 @@
+package ktanemod_remote_math_server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Metrics counts server events for the Prometheus metrics endpoint
+type Metrics struct {
+	PuzzlesCreated    atomic.Uint64
+	PuzzlesSolved     atomic.Uint64
+	PuzzlesAbandoned  atomic.Uint64
+	SolutionAttempts  atomic.Uint64
+	TwitchActivations atomic.Uint64
+	LogSaveFailures   atomic.Uint64
+
+	ModuleConns atomic.Int64
+	WebConns    atomic.Int64
+
+	ModuleUnknownPackets atomic.Uint64
+	WebUnknownPackets    atomic.Uint64
+
+	// steps counts the wrong and correct results of each solution step
+	steps [4][2]atomic.Uint64
+}
+
+// AddSteps counts the result of each step in a solution attempt
+func (m *Metrics) AddSteps(steps StepResults) {
+	for i, ok := range steps {
+		if ok {
+			m.steps[i][1].Add(1)
+		} else {
+			m.steps[i][0].Add(1)
+		}
+	}
+}
+
+// metric is a single sample with optional labels
+type metric struct {
+	labels string
+	value  string
+}
+
+func counter(v *atomic.Uint64) string { return strconv.FormatUint(v.Load(), 10) }
+
+func gauge(v *atomic.Int64) string { return strconv.FormatInt(v.Load(), 10) }
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	write := func(name, kind, help string, samples ...metric) {
+		c, _ := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		n += int64(c)
+		for _, i := range samples {
+			c, _ = fmt.Fprintf(bw, "%s%s %s\n", name, i.labels, i.value)
+			n += int64(c)
+		}
+	}
+
+	write("remote_math_puzzles_created_total", "counter", "Puzzles created by modules.",
+		metric{value: counter(&m.PuzzlesCreated)})
+	write("remote_math_puzzles_solved_total", "counter", "Puzzles solved with a correct solution.",
+		metric{value: counter(&m.PuzzlesSolved)})
+	write("remote_math_puzzles_abandoned_total", "counter", "Puzzles closed without being solved.",
+		metric{value: counter(&m.PuzzlesAbandoned)})
+	write("remote_math_solution_attempts_total", "counter", "Solutions submitted by web clients.",
+		metric{value: counter(&m.SolutionAttempts)})
+
+	steps := make([]metric, 0, len(m.steps)*2)
+	for i := range m.steps {
+		for j, result := range []string{"wrong", "correct"} {
+			steps = append(steps, metric{
+				labels: fmt.Sprintf(`{step="%d",result="%s"}`, i+1, result),
+				value:  counter(&m.steps[i][j]),
+			})
+		}
+	}
+	write("remote_math_solution_steps_total", "counter", "Results of each solution step.", steps...)
+
+	write("remote_math_connections", "gauge", "Active websocket connections by client type.",
+		metric{labels: `{client="module"}`, value: gauge(&m.ModuleConns)},
+		metric{labels: `{client="web"}`, value: gauge(&m.WebConns)})
+	write("remote_math_twitch_plays_activations_total", "counter", "Twitch Plays codes activated by modules.",
+		metric{value: counter(&m.TwitchActivations)})
+	write("remote_math_unknown_packets_total", "counter", "Packets which could not be decoded by client type.",
+		metric{labels: `{client="module"}`, value: counter(&m.ModuleUnknownPackets)},
+		metric{labels: `{client="web"}`, value: counter(&m.WebUnknownPackets)})
+	write("remote_math_log_save_failures_total", "counter", "Puzzle logs which failed to save.",
+		metric{value: counter(&m.LogSaveFailures)})
+
+	return n, bw.Flush()
+}
+
+func (s *Server) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = s.rm.metrics.WriteTo(rw)
+}
@@ -0,0 +1,93 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	regMetricHelp   = regexp.MustCompile(`^# HELP ([a-z_]+) [^\n]+$`)
+	regMetricType   = regexp.MustCompile(`^# TYPE ([a-z_]+) (counter|gauge)$`)
+	regMetricSample = regexp.MustCompile(`^([a-z_]+)(\{[a-z_]+="[^"]*"(,[a-z_]+="[^"]*")*})? -?[0-9]+$`)
+)
+
+// checkMetricsFormat checks every line is valid in the text exposition format
+// and each sample follows the HELP and TYPE lines of its metric
+func checkMetricsFormat(t *testing.T, s string) {
+	assert.True(t, strings.HasSuffix(s, "\n"))
+	var name string
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		if m := regMetricHelp.FindStringSubmatch(line); m != nil {
+			name = m[1]
+			continue
+		}
+		if m := regMetricType.FindStringSubmatch(line); m != nil {
+			assert.Equal(t, name, m[1], line)
+			continue
+		}
+		m := regMetricSample.FindStringSubmatch(line)
+		if assert.NotNil(t, m, line) {
+			assert.Equal(t, name, m[1], line)
+		}
+	}
+}
+
+func TestMetrics_WriteTo(t *testing.T) {
+	m := new(Metrics)
+	m.PuzzlesCreated.Add(3)
+	m.ModuleConns.Add(2)
+	m.AddSteps(StepResults{true, false, true, true})
+
+	var b strings.Builder
+	n, err := m.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	checkMetricsFormat(t, b.String())
+	assert.Contains(t, b.String(), `# HELP remote_math_puzzles_created_total Puzzles created by modules.
+# TYPE remote_math_puzzles_created_total counter
+remote_math_puzzles_created_total 3
+`)
+	assert.Contains(t, b.String(), `remote_math_solution_steps_total{step="1",result="wrong"} 0
+remote_math_solution_steps_total{step="1",result="correct"} 1
+remote_math_solution_steps_total{step="2",result="wrong"} 1
+`)
+	assert.Contains(t, b.String(), `# TYPE remote_math_connections gauge
+remote_math_connections{client="module"} 2
+remote_math_connections{client="web"} 0
+`)
+}
+
+func TestServer_handleMetrics(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.fruits = fruits1
+	p.cText = cText1
+	p.batteries = 2
+	p.ports = 3
+	p.RecvMod("PuzzleUnknown")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=468::0")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	s.rm.ClosePuzzle(p)
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	checkMetricsFormat(t, body)
+	assert.Contains(t, body, "\nremote_math_puzzles_created_total 1\n")
+	assert.Contains(t, body, "\nremote_math_puzzles_solved_total 1\n")
+	assert.Contains(t, body, "\nremote_math_puzzles_abandoned_total 0\n")
+	assert.Contains(t, body, "\nremote_math_solution_attempts_total 2\n")
+	assert.Contains(t, body, "\n"+`remote_math_unknown_packets_total{client="module"} 1`+"\n")
+
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,149 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// OriginRule matches the Origin header sent when opening a websocket
+//
+// Rules are written as "[scheme://]host[:port]":
+//
+//	""                    requests without an Origin header
+//	"*"                   any Origin header
+//	"example.com"         http or https on the default port
+//	"https://example.com" only https on the default port
+//	"*.example.com"       any subdomain of example.com
+//	"localhost:*"         any port
+//	"localhost:3000"      only port 3000
+type OriginRule struct {
+	Scheme string
+	Host   string
+	Port   string
+	// None matches requests without an Origin header
+	None bool
+	// Any matches every Origin header
+	Any bool
+}
+
+// ParseOriginRule parses a single origin rule
+func ParseOriginRule(s string) (OriginRule, error) {
+	switch s {
+	case "":
+		return OriginRule{None: true}, nil
+	case "*":
+		return OriginRule{Any: true}, nil
+	}
+
+	hostPort := s
+	var r OriginRule
+	if scheme, rest, ok := strings.Cut(hostPort, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return OriginRule{}, fmt.Errorf("origin rule '%s': scheme must be http or https", s)
+		}
+		r.Scheme = scheme
+		hostPort = rest
+	}
+	r.Host = hostPort
+	if strings.Contains(hostPort, ":") {
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return OriginRule{}, fmt.Errorf("origin rule '%s': %w", s, err)
+		}
+		if port == "" {
+			return OriginRule{}, fmt.Errorf("origin rule '%s': missing port", s)
+		}
+		r.Host, r.Port = host, port
+	}
+	r.Host = strings.ToLower(r.Host)
+	if r.Host == "" || strings.Contains(r.Host, "/") || strings.Contains(strings.TrimPrefix(r.Host, "*."), "*") {
+		return OriginRule{}, fmt.Errorf("origin rule '%s': invalid host", s)
+	}
+	return r, nil
+}
+
+// Match returns true if the Origin header value is allowed by the rule
+func (r OriginRule) Match(origin string) bool {
+	if origin == "" {
+		return r.None
+	}
+	if r.Any {
+		return true
+	}
+	if r.None {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch {
+	case r.Scheme != "" && u.Scheme != r.Scheme:
+		return false
+	case r.Scheme == "" && u.Scheme != "http" && u.Scheme != "https":
+		// rules without a scheme only match http and https
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	switch r.Port {
+	case "*":
+	case "":
+		if port != defaultPort(u.Scheme) {
+			return false
+		}
+	default:
+		if port != r.Port {
+			return false
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if suffix, ok := strings.CutPrefix(r.Host, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == r.Host
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// OriginRules allows an Origin header if any of the rules match
+type OriginRules []OriginRule
+
+// ParseOriginRules parses every rule and returns all the errors
+func ParseOriginRules(rules []string) (OriginRules, error) {
+	r := make(OriginRules, 0, len(rules))
+	var errs []error
+	for _, i := range rules {
+		rule, err := ParseOriginRule(i)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r = append(r, rule)
+	}
+	return r, errors.Join(errs...)
+}
+
+func (r OriginRules) Match(origin string) bool {
+	for _, i := range r {
+		if i.Match(origin) {
+			return true
+		}
+	}
+	return false
+}
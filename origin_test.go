@@ -0,0 +1,58 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testOriginRules = []struct {
+	rule   string
+	origin string
+	out    bool
+}{
+	{"", "", true},
+	{"", "https://example.com", false},
+	{"*", "", false},
+	{"*", "https://example.com", true},
+	{"example.com", "https://example.com", true},
+	{"example.com", "http://example.com", true},
+	{"example.com", "http://EXAMPLE.com", true},
+	{"example.com", "https://example.com:443", true},
+	{"example.com", "https://example.com:8443", false},
+	{"example.com", "https://a.example.com", false},
+	{"https://example.com", "https://example.com", true},
+	{"https://example.com", "http://example.com", false},
+	{"*.example.com", "https://a.example.com", true},
+	{"*.example.com", "https://a.b.example.com", true},
+	{"*.example.com", "https://example.com", false},
+	{"*.example.com", "https://badexample.com", false},
+	{"localhost:*", "http://localhost:3000", true},
+	{"localhost:*", "http://localhost", true},
+	{"localhost:3000", "http://localhost:3000", true},
+	{"localhost:3000", "http://localhost:3001", false},
+	{"http://127.0.0.1:*", "http://127.0.0.1:8080", true},
+	{"http://127.0.0.1:*", "https://127.0.0.1:8080", false},
+	{"example.com", "null", false},
+	{"example.com", "chrome-extension://example.com", false},
+	{"example.com", "ws://example.com", false},
+	{"localhost:*", "ws://localhost:3000", false},
+}
+
+func TestOriginRule_Match(t *testing.T) {
+	for _, row := range testOriginRules {
+		t.Run(row.rule+" "+row.origin, func(t *testing.T) {
+			r, err := ParseOriginRule(row.rule)
+			assert.NoError(t, err)
+			assert.Equal(t, row.out, r.Match(row.origin))
+		})
+	}
+}
+
+func TestParseOriginRules(t *testing.T) {
+	_, err := ParseOriginRules([]string{"ftp://example.com", "example.com:", "a.*.example.com", "example.com/path", "example.com"})
+	assert.ErrorContains(t, err, "origin rule 'ftp://example.com': scheme must be http or https")
+	assert.ErrorContains(t, err, "origin rule 'example.com:': missing port")
+	assert.ErrorContains(t, err, "origin rule 'a.*.example.com': invalid host")
+	assert.ErrorContains(t, err, "origin rule 'example.com/path': invalid host")
+	assert.NotContains(t, err.Error(), "'example.com'")
+}
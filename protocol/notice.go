@@ -0,0 +1,27 @@
+package protocol
+
+import "strings"
+
+// CapabilityNotice allows the server to send ServerNotice packets
+const CapabilityNotice = "notice"
+
+func init() {
+	register("ServerNotice", decodeServerNotice, ToModule, ToWeb)
+}
+
+// ServerNotice is a message from the server operator, for example a warning
+// before maintenance
+type ServerNotice struct {
+	Message string
+}
+
+func (ServerNotice) Name() string { return "ServerNotice" }
+
+func (p ServerNotice) args() []string { return []string{p.Message} }
+
+func decodeServerNotice(args []string) (Packet, error) {
+	if len(args) == 0 {
+		return nil, malformed("ServerNotice", "missing message")
+	}
+	return ServerNotice{Message: strings.Join(args, separator)}, nil
+}
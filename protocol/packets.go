@@ -0,0 +1,482 @@
+package protocol
+
+import "strings"
+
+const (
+	digits     = "0123456789"
+	letters    = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	equation   = "0123456789-+/*="
+	fruitCount = 6
+	lightCount = 6
+)
+
+// simple is implemented by packets without any arguments
+type simple struct{}
+
+func (simple) args() []string { return nil }
+
+// decodeSimple returns a decoder for a packet without any arguments
+func decodeSimple(p Packet) decoder {
+	return func(args []string) (Packet, error) {
+		if err := checkArgs(p.Name(), args, 0); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+func init() {
+	register("blåhaj", decodeSelectModule, Handshake)
+	register("rin", decodeSelectWeb, Handshake)
+	register("ClientSelected", decodeClientSelected, ToModule, ToWeb)
+	register("ping", decodeSimple(Ping{}), ToModule, ToWeb)
+	register("pong", decodeSimple(Pong{}), Handshake, FromModule, FromWeb)
+
+	register("PuzzleTwitchPlaysMode", decodePuzzleTwitchPlaysMode, FromModule)
+	register("PuzzleActivateTwitchCode", decodePuzzleActivateTwitchCode, FromModule)
+	register("PuzzleFruits", decodePuzzleFruits, FromModule)
+	register("BombDetails", decodeBombDetails, FromModule)
+	register("PuzzleTrainingMode", decodeSimple(PuzzleTrainingMode{}), FromModule, ToWeb)
+
+	register("PuzzleCode", decodePuzzleCode, ToModule)
+	register("PuzzleLog", decodePuzzleLog, ToModule)
+	register("PuzzleStrike", decodeSimple(PuzzleStrike{}), ToModule)
+	register("PuzzleTwitchCode", decodePuzzleTwitchCode, ToModule)
+	register("PuzzleComplete", decodeSimple(PuzzleComplete{}), ToModule, ToWeb)
+
+	register("PuzzleConnect", decodePuzzleConnect, FromWeb)
+	register("PuzzleSolution", decodePuzzleSolution, FromWeb)
+
+	register("PuzzleConnected", decodeSimple(PuzzleConnected{}), ToWeb)
+	register("PuzzleFruits", decodeExpertFruits, ToWeb)
+	register("PuzzleFruitText", decodePuzzleFruitText, ToWeb)
+	register("PuzzleTwitchCode", decodeExpertTwitchCode, ToWeb)
+	register("PuzzleActivateTwitchPlays", decodeSimple(PuzzleActivateTwitchPlays{}), ToWeb)
+	register("PuzzleStepResults", decodePuzzleStepResults, ToWeb)
+	register("PuzzleWrongSolution", decodePuzzleWrongSolution, ToWeb)
+}
+
+// SelectModule is sent by the module to select the module client type
+type SelectModule struct {
+	Features
+}
+
+func (SelectModule) Name() string { return "blåhaj" }
+
+func decodeSelectModule(args []string) (Packet, error) {
+	v, err := decodeFeatures("blåhaj", args)
+	if err != nil {
+		return nil, err
+	}
+	return SelectModule{v}, nil
+}
+
+// SelectWeb is sent by the web client to select the web client type
+type SelectWeb struct {
+	Features
+}
+
+func (SelectWeb) Name() string { return "rin" }
+
+func decodeSelectWeb(args []string) (Packet, error) {
+	v, err := decodeFeatures("rin", args)
+	if err != nil {
+		return nil, err
+	}
+	return SelectWeb{v}, nil
+}
+
+// ClientSelected confirms the client type was selected and contains the
+// negotiated version and capabilities
+type ClientSelected struct {
+	Features
+}
+
+func (ClientSelected) Name() string { return "ClientSelected" }
+
+func decodeClientSelected(args []string) (Packet, error) {
+	v, err := decodeFeatures("ClientSelected", args)
+	if err != nil {
+		return nil, err
+	}
+	return ClientSelected{v}, nil
+}
+
+// CapabilityControlPing is used by clients which reply to websocket ping
+// control frames and don't need the Ping packet
+const CapabilityControlPing = "control-ping"
+
+// Ping is sent by the server to check the client is still connected
+type Ping struct{ simple }
+
+func (Ping) Name() string { return "ping" }
+
+// Pong is the reply to Ping
+type Pong struct{ simple }
+
+func (Pong) Name() string { return "pong" }
+
+// PuzzleTwitchPlaysMode is sent by the module when Twitch Plays is active
+type PuzzleTwitchPlaysMode struct {
+	TwitchId string
+}
+
+func (PuzzleTwitchPlaysMode) Name() string { return "PuzzleTwitchPlaysMode" }
+
+func (p PuzzleTwitchPlaysMode) args() []string { return []string{p.TwitchId} }
+
+func decodePuzzleTwitchPlaysMode(args []string) (Packet, error) {
+	const name = "PuzzleTwitchPlaysMode"
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, err
+	}
+	if err := checkChars(name, args[0], digits); err != nil {
+		return nil, err
+	}
+	return PuzzleTwitchPlaysMode{TwitchId: args[0]}, nil
+}
+
+// PuzzleActivateTwitchCode is sent by the module when a Twitch Plays code is entered
+type PuzzleActivateTwitchCode struct {
+	Code string
+}
+
+func (PuzzleActivateTwitchCode) Name() string { return "PuzzleActivateTwitchCode" }
+
+func (p PuzzleActivateTwitchCode) args() []string { return []string{p.Code} }
+
+func decodePuzzleActivateTwitchCode(args []string) (Packet, error) {
+	const name = "PuzzleActivateTwitchCode"
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, err
+	}
+	if err := checkLen(name, args[0], 3, digits); err != nil {
+		return nil, err
+	}
+	return PuzzleActivateTwitchCode{Code: args[0]}, nil
+}
+
+// PuzzleFruits is sent by the module with the image and text index of all 8 fruits
+//
+//	[0] defuser top image, [1] defuser right image
+//	[2] defuser top text,  [3] defuser right text
+//	[4] expert left image, [5] expert right image
+//	[6] expert left text,  [7] expert right text
+type PuzzleFruits struct {
+	Fruits [8]int
+}
+
+func (PuzzleFruits) Name() string { return "PuzzleFruits" }
+
+func (p PuzzleFruits) args() []string { return intArgs(p.Fruits[:]) }
+
+func decodePuzzleFruits(args []string) (Packet, error) {
+	var p PuzzleFruits
+	if err := decodeInts("PuzzleFruits", args, p.Fruits[:], fruitCount-1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// BombDetails is sent by the module with the edgework used in the calculations
+type BombDetails struct {
+	Batteries int
+	Ports     int
+}
+
+func (BombDetails) Name() string { return "BombDetails" }
+
+func (p BombDetails) args() []string { return []string{itoa(p.Batteries), itoa(p.Ports)} }
+
+func decodeBombDetails(args []string) (Packet, error) {
+	var a [2]int
+	if err := decodeInts("BombDetails", args, a[:], maxInt32); err != nil {
+		return nil, err
+	}
+	return BombDetails{Batteries: a[0], Ports: a[1]}, nil
+}
+
+// CapabilityTraining is used by web clients which can show the training mode
+// and the per-step results of each solution
+const CapabilityTraining = "training"
+
+// PuzzleTrainingMode is sent by the module to enable per-step results for the
+// expert, the server forwards it to the web clients
+type PuzzleTrainingMode struct{ simple }
+
+func (PuzzleTrainingMode) Name() string { return "PuzzleTrainingMode" }
+
+// PuzzleCode tells the module its puzzle code
+type PuzzleCode struct {
+	Code string
+}
+
+func (PuzzleCode) Name() string { return "PuzzleCode" }
+
+func (p PuzzleCode) args() []string { return []string{p.Code} }
+
+func decodePuzzleCode(args []string) (Packet, error) {
+	code, err := decodeCode("PuzzleCode", args)
+	if err != nil {
+		return nil, err
+	}
+	return PuzzleCode{Code: code}, nil
+}
+
+// PuzzleLog is a message for the module to write to the game log
+type PuzzleLog struct {
+	Message string
+}
+
+func (PuzzleLog) Name() string { return "PuzzleLog" }
+
+func (p PuzzleLog) args() []string { return []string{p.Message} }
+
+func decodePuzzleLog(args []string) (Packet, error) {
+	if len(args) == 0 {
+		return nil, malformed("PuzzleLog", "missing message")
+	}
+	return PuzzleLog{Message: strings.Join(args, separator)}, nil
+}
+
+// CapabilityStrike is used by clients which handle wrong solutions, modules
+// receive PuzzleStrike and web clients receive PuzzleWrongSolution
+const CapabilityStrike = "strike"
+
+// PuzzleStrike tells the module to give a strike for a wrong solution
+type PuzzleStrike struct{ simple }
+
+func (PuzzleStrike) Name() string { return "PuzzleStrike" }
+
+// PuzzleTwitchCode tells the module which Twitch Plays code to expect
+type PuzzleTwitchCode struct {
+	Code string
+}
+
+func (PuzzleTwitchCode) Name() string { return "PuzzleTwitchCode" }
+
+func (p PuzzleTwitchCode) args() []string { return []string{p.Code} }
+
+func decodePuzzleTwitchCode(args []string) (Packet, error) {
+	const name = "PuzzleTwitchCode"
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, err
+	}
+	if err := checkLen(name, args[0], 3, digits); err != nil {
+		return nil, err
+	}
+	return PuzzleTwitchCode{Code: args[0]}, nil
+}
+
+// PuzzleComplete is sent to the module and web clients once the puzzle is solved
+type PuzzleComplete struct{ simple }
+
+func (PuzzleComplete) Name() string { return "PuzzleComplete" }
+
+// PuzzleConnect is sent by the web client to join the puzzle with the code
+type PuzzleConnect struct {
+	Code string
+}
+
+func (PuzzleConnect) Name() string { return "PuzzleConnect" }
+
+func (p PuzzleConnect) args() []string { return []string{p.Code} }
+
+func decodePuzzleConnect(args []string) (Packet, error) {
+	code, err := decodeCode("PuzzleConnect", args)
+	if err != nil {
+		return nil, err
+	}
+	return PuzzleConnect{Code: code}, nil
+}
+
+// PuzzleSolution is sent by the web client with the expert's answers
+type PuzzleSolution struct {
+	// Left is the number for the left fruit
+	Left int
+	// Right is the number for the right fruit
+	Right int
+	// Display is the equation shown on the display
+	Display string
+	// Status is the index of the status light colour
+	Status int
+}
+
+func (PuzzleSolution) Name() string { return "PuzzleSolution" }
+
+func (p PuzzleSolution) args() []string {
+	return []string{itoa(p.Left), itoa(p.Right), p.Display, itoa(p.Status)}
+}
+
+func decodePuzzleSolution(args []string) (Packet, error) {
+	const name = "PuzzleSolution"
+	if err := checkArgs(name, args, 4); err != nil {
+		return nil, err
+	}
+	left, err := parseInt(name, args[0], 0, maxInt32)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseInt(name, args[1], 0, maxInt32)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChars(name, args[2], equation); err != nil {
+		return nil, err
+	}
+	status, err := parseInt(name, args[3], 0, lightCount-1)
+	if err != nil {
+		return nil, err
+	}
+	return PuzzleSolution{Left: left, Right: right, Display: args[2], Status: status}, nil
+}
+
+// PuzzleConnected confirms the web client joined the puzzle
+type PuzzleConnected struct{ simple }
+
+func (PuzzleConnected) Name() string { return "PuzzleConnected" }
+
+// ExpertFruits tells the web client about the expert's fruits
+//
+//	[0] left image, [1] right image, [2] left text, [3] right text
+type ExpertFruits struct {
+	Fruits [4]int
+}
+
+func (ExpertFruits) Name() string { return "PuzzleFruits" }
+
+func (p ExpertFruits) args() []string { return intArgs(p.Fruits[:]) }
+
+func decodeExpertFruits(args []string) (Packet, error) {
+	var p ExpertFruits
+	if err := decodeInts("PuzzleFruits", args, p.Fruits[:], fruitCount-1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PuzzleFruitText tells the web client the two valid status light colours
+type PuzzleFruitText struct {
+	Text [2]int
+}
+
+func (PuzzleFruitText) Name() string { return "PuzzleFruitText" }
+
+func (p PuzzleFruitText) args() []string { return intArgs(p.Text[:]) }
+
+func decodePuzzleFruitText(args []string) (Packet, error) {
+	var p PuzzleFruitText
+	if err := decodeInts("PuzzleFruitText", args, p.Text[:], lightCount-1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ExpertTwitchCode tells the web client which code to enter through Twitch Plays
+type ExpertTwitchCode struct {
+	TwitchId string
+	Code     string
+}
+
+func (ExpertTwitchCode) Name() string { return "PuzzleTwitchCode" }
+
+func (p ExpertTwitchCode) args() []string { return []string{p.TwitchId, p.Code} }
+
+func decodeExpertTwitchCode(args []string) (Packet, error) {
+	const name = "PuzzleTwitchCode"
+	if err := checkArgs(name, args, 2); err != nil {
+		return nil, err
+	}
+	if err := checkChars(name, args[0], digits); err != nil {
+		return nil, err
+	}
+	if err := checkLen(name, args[1], 3, digits); err != nil {
+		return nil, err
+	}
+	return ExpertTwitchCode{TwitchId: args[0], Code: args[1]}, nil
+}
+
+// PuzzleActivateTwitchPlays tells the web client its Twitch Plays code was entered
+type PuzzleActivateTwitchPlays struct{ simple }
+
+func (PuzzleActivateTwitchPlays) Name() string { return "PuzzleActivateTwitchPlays" }
+
+// PuzzleStepResults tells the web client which steps were correct in training mode
+type PuzzleStepResults struct {
+	Steps [4]bool
+}
+
+func (PuzzleStepResults) Name() string { return "PuzzleStepResults" }
+
+func (p PuzzleStepResults) args() []string {
+	a := make([]string, len(p.Steps))
+	for i, s := range p.Steps {
+		a[i] = bool01(s)
+	}
+	return a
+}
+
+func decodePuzzleStepResults(args []string) (Packet, error) {
+	var a [4]int
+	if err := decodeInts("PuzzleStepResults", args, a[:], 1); err != nil {
+		return nil, err
+	}
+	var p PuzzleStepResults
+	for i := range a {
+		p.Steps[i] = a[i] == 1
+	}
+	return p, nil
+}
+
+// PuzzleWrongSolution tells the web client the solution was wrong
+type PuzzleWrongSolution struct {
+	Attempt int
+}
+
+func (PuzzleWrongSolution) Name() string { return "PuzzleWrongSolution" }
+
+func (p PuzzleWrongSolution) args() []string { return []string{itoa(p.Attempt)} }
+
+func decodePuzzleWrongSolution(args []string) (Packet, error) {
+	var a [1]int
+	if err := decodeInts("PuzzleWrongSolution", args, a[:], maxInt32); err != nil {
+		return nil, err
+	}
+	return PuzzleWrongSolution{Attempt: a[0]}, nil
+}
+
+const maxInt32 = 1<<31 - 1
+
+func intArgs(a []int) []string {
+	s := make([]string, len(a))
+	for i, n := range a {
+		s[i] = itoa(n)
+	}
+	return s
+}
+
+// decodeInts parses exactly len(out) integers between 0 and hi inclusive
+func decodeInts(name string, args []string, out []int, hi int) error {
+	if err := checkArgs(name, args, len(out)); err != nil {
+		return err
+	}
+	for i := range out {
+		n, err := parseInt(name, args[i], 0, hi)
+		if err != nil {
+			return err
+		}
+		out[i] = n
+	}
+	return nil
+}
+
+// decodeCode parses a single 6 letter puzzle code
+func decodeCode(name string, args []string) (string, error) {
+	if err := checkArgs(name, args, 1); err != nil {
+		return "", err
+	}
+	if err := checkLen(name, args[0], 6, letters); err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
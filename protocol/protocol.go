@@ -0,0 +1,177 @@
+// Package protocol contains the packets sent between the Remote Math module,
+// the Remote Math web client and the server.
+//
+// Packets are encoded as a name followed by zero or more arguments, each
+// separated by "::".
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const separator = "::"
+
+// Packet is implemented by every packet type in this package
+type Packet interface {
+	// Name returns the first segment of the encoded packet
+	Name() string
+	args() []string
+}
+
+// Direction selects which set of packets a raw message is decoded against
+type Direction byte
+
+const (
+	// Handshake packets are sent by a new connection to select the client type
+	Handshake Direction = iota
+	// FromModule packets are sent by the module to the server
+	FromModule
+	// ToModule packets are sent by the server to the module
+	ToModule
+	// FromWeb packets are sent by the web client to the server
+	FromWeb
+	// ToWeb packets are sent by the server to the web client
+	ToWeb
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Handshake:
+		return "handshake"
+	case FromModule:
+		return "from module"
+	case ToModule:
+		return "to module"
+	case FromWeb:
+		return "from web"
+	case ToWeb:
+		return "to web"
+	}
+	return "unknown direction"
+}
+
+// ErrUnknownPacket is matched by every UnknownPacketError
+var ErrUnknownPacket = errors.New("unknown packet")
+
+// UnknownPacketError is returned when the packet name is not valid for the direction
+type UnknownPacketError struct {
+	Direction Direction
+	Raw       string
+}
+
+func (e *UnknownPacketError) Error() string {
+	return fmt.Sprintf("unknown packet '%s' %s", e.Raw, e.Direction)
+}
+
+func (e *UnknownPacketError) Is(target error) bool { return target == ErrUnknownPacket }
+
+// ErrMalformedPacket is matched by every MalformedPacketError
+var ErrMalformedPacket = errors.New("malformed packet")
+
+// MalformedPacketError is returned when a known packet has invalid arguments
+type MalformedPacketError struct {
+	Packet string
+	Reason string
+}
+
+func (e *MalformedPacketError) Error() string {
+	return fmt.Sprintf("malformed %s packet: %s", e.Packet, e.Reason)
+}
+
+func (e *MalformedPacketError) Is(target error) bool { return target == ErrMalformedPacket }
+
+// Encode converts the packet into the text sent over the websocket
+func Encode(p Packet) string {
+	a := p.args()
+	if len(a) == 0 {
+		return p.Name()
+	}
+	return p.Name() + separator + strings.Join(a, separator)
+}
+
+type decoder func(args []string) (Packet, error)
+
+var decoders = map[Direction]map[string]decoder{}
+
+// register adds a decoder for the named packet in each direction
+func register(name string, d decoder, dirs ...Direction) {
+	for _, dir := range dirs {
+		m := decoders[dir]
+		if m == nil {
+			m = make(map[string]decoder)
+			decoders[dir] = m
+		}
+		m[name] = d
+	}
+}
+
+// Decode parses the raw message as a packet valid for the direction
+func Decode(dir Direction, s string) (Packet, error) {
+	parts := strings.Split(s, separator)
+	d, ok := decoders[dir][parts[0]]
+	if !ok {
+		return nil, &UnknownPacketError{Direction: dir, Raw: s}
+	}
+	return d(parts[1:])
+}
+
+func malformed(name, format string, a ...any) error {
+	return &MalformedPacketError{Packet: name, Reason: fmt.Sprintf(format, a...)}
+}
+
+func checkArgs(name string, args []string, n int) error {
+	if len(args) != n {
+		return malformed(name, "expected %d arguments but got %d", n, len(args))
+	}
+	return nil
+}
+
+// parseInt parses a base 10 integer between lo and hi inclusive
+func parseInt(name string, s string, lo, hi int) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, malformed(name, "'%s' is not a number", s)
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, malformed(name, "'%s' is out of range", s)
+	}
+	if int(n) < lo || int(n) > hi {
+		return 0, malformed(name, "%d is not between %d and %d", n, lo, hi)
+	}
+	return int(n), nil
+}
+
+// checkChars makes sure the string is non-empty and only contains the chars
+func checkChars(name string, s string, chars string) error {
+	if s == "" {
+		return malformed(name, "empty argument")
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(chars, c) {
+			return malformed(name, "invalid character '%c' in '%s'", c, s)
+		}
+	}
+	return nil
+}
+
+// checkLen makes sure the string has the exact length and only contains the chars
+func checkLen(name string, s string, l int, chars string) error {
+	if len(s) != l {
+		return malformed(name, "'%s' should be %d characters long", s, l)
+	}
+	return checkChars(name, s, chars)
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func bool01(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testRoundTrip = []struct {
+	dir    Direction
+	raw    string
+	packet Packet
+}{
+	{Handshake, "blåhaj", SelectModule{Features{Version: 1}}},
+	{Handshake, "blåhaj::2", SelectModule{Features{Version: 2}}},
+	{Handshake, "blåhaj::2::resume,training", SelectModule{Features{Version: 2, Capabilities: []string{"resume", "training"}}}},
+	{Handshake, "rin", SelectWeb{Features{Version: 1}}},
+	{Handshake, "rin::3::resume", SelectWeb{Features{Version: 3, Capabilities: []string{"resume"}}}},
+	{Handshake, "pong", Pong{}},
+	{ToWeb, "ping", Ping{}},
+	{ToModule, "ClientSelected", ClientSelected{Features{Version: 1}}},
+	{ToWeb, "ClientSelected::2::resume", ClientSelected{Features{Version: 2, Capabilities: []string{"resume"}}}},
+	{FromModule, "PuzzleTwitchPlaysMode::42", PuzzleTwitchPlaysMode{TwitchId: "42"}},
+	{FromModule, "PuzzleActivateTwitchCode::042", PuzzleActivateTwitchCode{Code: "042"}},
+	{FromModule, "PuzzleFruits::1::3::4::1::0::3::5::2", PuzzleFruits{Fruits: [8]int{1, 3, 4, 1, 0, 3, 5, 2}}},
+	{FromModule, "BombDetails::2::3", BombDetails{Batteries: 2, Ports: 3}},
+	{FromModule, "PuzzleTrainingMode", PuzzleTrainingMode{}},
+	{ToModule, "PuzzleCode::ABCDEF", PuzzleCode{Code: "ABCDEF"}},
+	{ToModule, "PuzzleLog::LogFile/2023-01-02/ABCDEF", PuzzleLog{Message: "LogFile/2023-01-02/ABCDEF"}},
+	{ToModule, "PuzzleLog::a::b", PuzzleLog{Message: "a::b"}},
+	{ToModule, "PuzzleStrike", PuzzleStrike{}},
+	{ToModule, "PuzzleTwitchCode::123", PuzzleTwitchCode{Code: "123"}},
+	{ToModule, "PuzzleComplete", PuzzleComplete{}},
+	{FromWeb, "PuzzleConnect::abcdef", PuzzleConnect{Code: "abcdef"}},
+	{FromWeb, "PuzzleSolution::2::12::14+91*5=469::0", PuzzleSolution{Left: 2, Right: 12, Display: "14+91*5=469", Status: 0}},
+	{ToWeb, "PuzzleConnected", PuzzleConnected{}},
+	{ToWeb, "PuzzleFruits::0::3::5::2", ExpertFruits{Fruits: [4]int{0, 3, 5, 2}}},
+	{ToWeb, "PuzzleFruitText::0::1", PuzzleFruitText{Text: [2]int{0, 1}}},
+	{ToWeb, "PuzzleTwitchCode::42::123", ExpertTwitchCode{TwitchId: "42", Code: "123"}},
+	{ToWeb, "PuzzleActivateTwitchPlays", PuzzleActivateTwitchPlays{}},
+	{ToWeb, "PuzzleTrainingMode", PuzzleTrainingMode{}},
+	{ToWeb, "PuzzleStepResults::1::0::1::1", PuzzleStepResults{Steps: [4]bool{true, false, true, true}}},
+	{ToWeb, "PuzzleWrongSolution::3", PuzzleWrongSolution{Attempt: 3}},
+	{ToWeb, "PuzzleComplete", PuzzleComplete{}},
+	{Handshake, "PuzzleResume::ABCDEF::0123456789abcdef0123456789abcdef", PuzzleResume{Code: "ABCDEF", Token: "0123456789abcdef0123456789abcdef"}},
+	{ToModule, "PuzzleResumeToken::0123456789abcdef0123456789abcdef", PuzzleResumeToken{Token: "0123456789abcdef0123456789abcdef"}},
+	{ToModule, "PuzzleResumed", PuzzleResumed{}},
+	{ToModule, "PuzzleResumeFailed", PuzzleResumeFailed{}},
+	{FromWeb, "PuzzleRejoin::abcdef::0123456789abcdef0123456789abcdef", PuzzleRejoin{Code: "abcdef", Token: "0123456789abcdef0123456789abcdef"}},
+	{ToModule, "ServerNotice::Restarting in 5 minutes", ServerNotice{Message: "Restarting in 5 minutes"}},
+	{ToWeb, "ServerNotice::a::b", ServerNotice{Message: "a::b"}},
+	{FromModule, "PuzzleRuleSet::default", PuzzleRuleSet{Id: "default"}},
+	{ToWeb, "PuzzleRuleSet::community-v1.2", PuzzleRuleSet{Id: "community-v1.2"}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, row := range testRoundTrip {
+		t.Run(row.raw, func(t *testing.T) {
+			p, err := Decode(row.dir, row.raw)
+			assert.NoError(t, err)
+			assert.Equal(t, row.packet, p)
+			assert.Equal(t, row.raw, Encode(row.packet))
+		})
+	}
+}
+
+var testMalformed = []struct {
+	dir Direction
+	raw string
+}{
+	{FromModule, "PuzzleFruits::1::3::4::1::0::3::5"},
+	{FromModule, "PuzzleFruits::1::3::4::1::0::3::5::6"},
+	{FromModule, "PuzzleActivateTwitchCode::12"},
+	{FromModule, "PuzzleActivateTwitchCode::12a"},
+	{FromModule, "PuzzleTwitchPlaysMode::"},
+	{FromModule, "BombDetails::-1::3"},
+	{FromModule, "BombDetails::99999999999::3"},
+	{FromModule, "PuzzleTrainingMode::1"},
+	{FromWeb, "PuzzleConnect::ABCDE"},
+	{FromWeb, "PuzzleConnect::ABCDE1"},
+	{FromWeb, "PuzzleSolution::2::12::14+91*5=469::6"},
+	{FromWeb, "PuzzleSolution::2::12::14+91x5=469::0"},
+	{FromWeb, "PuzzleSolution::2::12::::0"},
+	{ToWeb, "PuzzleStepResults::1::0::2::1"},
+	{Handshake, "PuzzleResume::ABCDEF::0123456789ABCDEF0123456789abcdef"},
+	{FromWeb, "PuzzleRejoin::ABCDEF::0123"},
+	{FromModule, "PuzzleRuleSet::"},
+	{FromModule, "PuzzleRuleSet::a b"},
+	{FromModule, "PuzzleRuleSet::0123456789abcdef0123456789abcdefg"},
+	{Handshake, "blåhaj::1"},
+	{Handshake, "blåhaj::0"},
+	{Handshake, "rin::2::Resume"},
+	{Handshake, "rin::2::resume,,training"},
+	{Handshake, "rin::2::resume::training"},
+}
+
+func TestDecode_Malformed(t *testing.T) {
+	for _, row := range testMalformed {
+		t.Run(row.raw, func(t *testing.T) {
+			_, err := Decode(row.dir, row.raw)
+			assert.ErrorIs(t, err, ErrMalformedPacket)
+			var malformedErr *MalformedPacketError
+			assert.ErrorAs(t, err, &malformedErr)
+		})
+	}
+}
+
+func TestDecode_Unknown(t *testing.T) {
+	_, err := Decode(FromWeb, "PuzzleFruits::1::3::4::1::0::3::5::2")
+	assert.ErrorIs(t, err, ErrUnknownPacket)
+	_, err = Decode(Handshake, "hello")
+	assert.ErrorIs(t, err, ErrUnknownPacket)
+	_, err = Decode(FromModule, "")
+	assert.ErrorIs(t, err, ErrUnknownPacket)
+}
+
+func TestFeatures_Negotiate(t *testing.T) {
+	server := Features{Version: 2, Capabilities: []string{"resume", "training"}}
+	assert.Equal(t, Features{Version: 1}, server.Negotiate(Features{Version: 1}))
+	assert.Equal(t, Features{Version: 2}, server.Negotiate(Features{Version: 2}))
+	assert.Equal(t, Features{Version: 2, Capabilities: []string{"training"}}, server.Negotiate(Features{Version: 5, Capabilities: []string{"training", "unknown"}}))
+}
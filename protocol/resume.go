@@ -0,0 +1,104 @@
+package protocol
+
+const (
+	// CapabilityResume allows a client to reattach to a puzzle after the
+	// connection drops
+	CapabilityResume = "resume"
+
+	// TokenLength is the length of a resume token
+	TokenLength = 32
+
+	hexChars = "0123456789abcdef"
+)
+
+func init() {
+	register("PuzzleResume", decodePuzzleResume, Handshake)
+	register("PuzzleResumeToken", decodePuzzleResumeToken, ToModule, ToWeb)
+	register("PuzzleResumed", decodeSimple(PuzzleResumed{}), ToModule)
+	register("PuzzleResumeFailed", decodeSimple(PuzzleResumeFailed{}), ToModule)
+	register("PuzzleRejoin", decodePuzzleRejoin, FromWeb)
+}
+
+// PuzzleResume is sent by the module instead of SelectModule to reattach to
+// an existing puzzle
+type PuzzleResume struct {
+	Code  string
+	Token string
+}
+
+func (PuzzleResume) Name() string { return "PuzzleResume" }
+
+func (p PuzzleResume) args() []string { return []string{p.Code, p.Token} }
+
+func decodePuzzleResume(args []string) (Packet, error) {
+	code, token, err := decodeCodeToken("PuzzleResume", args)
+	if err != nil {
+		return nil, err
+	}
+	return PuzzleResume{Code: code, Token: token}, nil
+}
+
+// PuzzleResumeToken contains the token used to resume or rejoin the puzzle
+type PuzzleResumeToken struct {
+	Token string
+}
+
+func (PuzzleResumeToken) Name() string { return "PuzzleResumeToken" }
+
+func (p PuzzleResumeToken) args() []string { return []string{p.Token} }
+
+func decodePuzzleResumeToken(args []string) (Packet, error) {
+	const name = "PuzzleResumeToken"
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, err
+	}
+	if err := checkLen(name, args[0], TokenLength, hexChars); err != nil {
+		return nil, err
+	}
+	return PuzzleResumeToken{Token: args[0]}, nil
+}
+
+// PuzzleResumed confirms the module reattached to the puzzle
+type PuzzleResumed struct{ simple }
+
+func (PuzzleResumed) Name() string { return "PuzzleResumed" }
+
+// PuzzleResumeFailed tells the module the puzzle can't be resumed, the module
+// should select a new puzzle instead
+type PuzzleResumeFailed struct{ simple }
+
+func (PuzzleResumeFailed) Name() string { return "PuzzleResumeFailed" }
+
+// PuzzleRejoin is sent by the web client instead of PuzzleConnect to rejoin
+// the puzzle with the same Twitch Plays state
+type PuzzleRejoin struct {
+	Code  string
+	Token string
+}
+
+func (PuzzleRejoin) Name() string { return "PuzzleRejoin" }
+
+func (p PuzzleRejoin) args() []string { return []string{p.Code, p.Token} }
+
+func decodePuzzleRejoin(args []string) (Packet, error) {
+	code, token, err := decodeCodeToken("PuzzleRejoin", args)
+	if err != nil {
+		return nil, err
+	}
+	return PuzzleRejoin{Code: code, Token: token}, nil
+}
+
+// decodeCodeToken parses a puzzle code followed by a resume token
+func decodeCodeToken(name string, args []string) (string, string, error) {
+	if err := checkArgs(name, args, 2); err != nil {
+		return "", "", err
+	}
+	code, err := decodeCode(name, args[:1])
+	if err != nil {
+		return "", "", err
+	}
+	if err := checkLen(name, args[1], TokenLength, hexChars); err != nil {
+		return "", "", err
+	}
+	return code, args[1], nil
+}
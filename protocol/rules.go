@@ -0,0 +1,40 @@
+package protocol
+
+const (
+	// CapabilityRuleSet allows the module to select the rules used to check
+	// solutions and tells web clients which rules are in use
+	CapabilityRuleSet = "rule-set"
+
+	// MaxRuleSetLength is the longest rule set id
+	MaxRuleSetLength = 32
+
+	ruleSetChars = letters + digits + "-_."
+)
+
+func init() {
+	register("PuzzleRuleSet", decodePuzzleRuleSet, FromModule, ToModule, ToWeb)
+}
+
+// PuzzleRuleSet is sent by the module during setup to select a rule set, the
+// server replies with the rule set in use and sends it to web clients
+type PuzzleRuleSet struct {
+	Id string
+}
+
+func (PuzzleRuleSet) Name() string { return "PuzzleRuleSet" }
+
+func (p PuzzleRuleSet) args() []string { return []string{p.Id} }
+
+func decodePuzzleRuleSet(args []string) (Packet, error) {
+	const name = "PuzzleRuleSet"
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, err
+	}
+	if len(args[0]) > MaxRuleSetLength {
+		return nil, malformed(name, "rule set id is longer than %d characters", MaxRuleSetLength)
+	}
+	if err := checkChars(name, args[0], ruleSetChars); err != nil {
+		return nil, err
+	}
+	return PuzzleRuleSet{Id: args[0]}, nil
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// LegacyVersion is used by clients sending the bare handshake
+	LegacyVersion = 1
+	// CurrentVersion is the newest version known by this package
+	CurrentVersion = 2
+
+	capabilityChars = "abcdefghijklmnopqrstuvwxyz0123456789-"
+)
+
+// Features is the protocol version and capability list sent in the handshake
+//
+// Version 1 is encoded as the bare packet name and has no capabilities, newer
+// versions are encoded as "name::version::cap1,cap2".
+type Features struct {
+	Version      int
+	Capabilities []string
+}
+
+// Has returns true if the capability is in the list
+func (v Features) Has(capability string) bool {
+	for _, i := range v.Capabilities {
+		if i == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate returns the highest version and the capabilities supported by
+// both sides
+func (v Features) Negotiate(other Features) Features {
+	n := Features{Version: min(v.Version, other.Version)}
+	if n.Version <= LegacyVersion {
+		return Features{Version: LegacyVersion}
+	}
+	for _, i := range v.Capabilities {
+		if other.Has(i) {
+			n.Capabilities = append(n.Capabilities, i)
+		}
+	}
+	return n
+}
+
+func (v Features) String() string {
+	if len(v.Capabilities) == 0 {
+		return fmt.Sprintf("version %d", v.Version)
+	}
+	return fmt.Sprintf("version %d (%s)", v.Version, strings.Join(v.Capabilities, ", "))
+}
+
+func (v Features) args() []string {
+	if v.Version <= LegacyVersion {
+		return nil
+	}
+	if len(v.Capabilities) == 0 {
+		return []string{itoa(v.Version)}
+	}
+	return []string{itoa(v.Version), strings.Join(v.Capabilities, ",")}
+}
+
+func decodeFeatures(name string, args []string) (Features, error) {
+	switch len(args) {
+	case 0:
+		return Features{Version: LegacyVersion}, nil
+	case 1, 2:
+	default:
+		return Features{}, malformed(name, "expected at most 2 arguments but got %d", len(args))
+	}
+	n, err := parseInt(name, args[0], LegacyVersion+1, maxInt32)
+	if err != nil {
+		return Features{}, err
+	}
+	v := Features{Version: n}
+	if len(args) == 2 && args[1] != "" {
+		v.Capabilities = strings.Split(args[1], ",")
+		for _, i := range v.Capabilities {
+			if err := checkChars(name, i, capabilityChars); err != nil {
+				return Features{}, err
+			}
+		}
+	}
+	return v, nil
+}
@@ -1,61 +1,64 @@
 package ktanemod_remote_math_server
 
 import (
-	"bytes"
-	"fmt"
-	"github.com/gorilla/websocket"
+	"errors"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
 	"io"
 	"log"
-	"math"
 	"os"
-	"regexp"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var (
-	regPuzzleSolution           = regexp.MustCompile("^PuzzleSolution::([0-9]+)::([0-9]+)::([0-9-+/*=]+)::([0-5])$")
-	regPuzzleTwitchPlaysMode    = regexp.MustCompile("^PuzzleTwitchPlaysMode::([0-9]+)$")
-	regPuzzleActivateTwitchCode = regexp.MustCompile("^PuzzleActivateTwitchCode::([0-9]{3})$")
-	regPuzzleFruits             = regexp.MustCompile("^PuzzleFruits::([0-5])::([0-5])::([0-5])::([0-5])::([0-5])::([0-5])::([0-5])::([0-5])$")
-	regBombDetails              = regexp.MustCompile("^BombDetails::([0-9]+)::([0-9]+)$")
-)
-
-var (
-	fruitNames   = []string{"Apple", "Melon", "Orange", "Pear", "Pineapple", "Strawberry"}
-	fruitNumbers = [][]int{
-		{88, 1, 48, 75, 31, 8},
-		{84, 42, 62, 21, 91, 17},
-		{56, 29, 12, 53, 11, 81},
-		{32, 5, 19, 38, 25, 64},
-		{44, 61, 20, 92, 13, 4},
-		{34, 50, 87, 22, 54, 19},
-	}
-)
+var fruitNames = []string{"Apple", "Melon", "Orange", "Pear", "Pineapple", "Strawberry"}
 
 type Puzzle struct {
 	code        string
 	date        time.Time
 	saveLog     *atomic.Bool
-	logRaw      *bytes.Buffer
+	logRaw      *logBuffer
+	events      *logBuffer
 	log         *log.Logger
-	modConn     *websocket.Conn
+	modConnLock *sync.Mutex
+	modConn     *Conn
+	modPending  []protocol.Packet
+	modFeatures protocol.Features
+	resumeToken string
+	detachTimer *time.Timer
 	webConnLock *sync.RWMutex
 	webConns    []*WebConn
+	departed    map[string]*WebConn
+	// experts counts the web conns which have connected to name them in events
+	experts     int
 	twitchPlays bool
 	twitchId    string
+	training    bool
 	killed      *atomic.Bool
+	solved      *atomic.Bool
+	attempts    *atomic.Int32
+
+	// solveCloseDelay is how long to wait before closing the module connection
+	// after the puzzle is solved
+	solveCloseDelay time.Duration
+	// resumeGracePeriod is how long a departed web conn can rejoin
+	resumeGracePeriod time.Duration
+	// metrics is shared by every puzzle of the RemoteMath
+	metrics *Metrics
 
 	batteries int
 	ports     int
 	fruits    [8]int
 	cText     [2]int
+
+	// rulesLock guards the rules and is held while counting solution attempts
+	// so the rules can't change once the first attempt is checked
+	rulesLock sync.RWMutex
+	rules     RuleSet
 }
 
-func NewPuzzle(conn *websocket.Conn, debug bool) *Puzzle {
-	logRaw := new(bytes.Buffer)
+func NewPuzzle(conn *Conn, debug bool) *Puzzle {
+	logRaw := new(logBuffer)
 	var logOut io.Writer
 	if debug {
 		logOut = io.MultiWriter(logRaw, log.New(os.Stderr, "DebugPuzzle", 0).Writer())
@@ -66,81 +69,89 @@ func NewPuzzle(conn *websocket.Conn, debug bool) *Puzzle {
 		date:        time.Now(),
 		saveLog:     new(atomic.Bool),
 		logRaw:      logRaw,
+		events:      new(logBuffer),
 		log:         log.New(logOut, "", 0),
+		modConnLock: new(sync.Mutex),
 		modConn:     conn,
 		webConnLock: new(sync.RWMutex),
 		webConns:    make([]*WebConn, 0),
+		departed:    make(map[string]*WebConn),
 		killed:      new(atomic.Bool),
+		solved:      new(atomic.Bool),
+		attempts:    new(atomic.Int32),
+		metrics:     new(Metrics),
+		rules:       DefaultRules,
 	}
 }
 
 type WebConn struct {
-	conn   *websocket.Conn
-	tpDone bool
-	tpCode string
+	conn *Conn
+	// name identifies the web conn in the saved logs without its address
+	name        string
+	features    protocol.Features
+	resumeToken string
+	tpDone      bool
+	tpCode      string
+	departTimer *time.Timer
 }
 
-func (p *Puzzle) CheckSolution(sln []string) bool {
-	sln1 := mustParseInt(sln[1])
-	sln2 := mustParseInt(sln[2])
-	sln3 := sln[3]
-	sln4 := mustParseInt(sln[4])
-	// Solution :: [1] Left fruit :: [2] Right fruit :: [3] Display content :: [4] Status light colour
-	// Fruit numbers
-	/* f1 = defuser's top
-	 * f2 = defuser's right
-	 * f3 = expert's left
-	 * f4 = expert's right
-	 */
-
-	f1 := fruitNumbers[p.fruits[0]][p.fruits[2]] // top defuser
-	f2 := fruitNumbers[p.fruits[1]][p.fruits[3]] // right defuser
-	f3 := fruitNumbers[p.fruits[4]][p.fruits[6]] // left expert
-	f4 := fruitNumbers[p.fruits[5]][p.fruits[7]] // right expert
-
-	_ = f3 // I used the variable now lol
-
-	// Step 1
-	s1f := float64(f1 * 13)
-	if p.fruits[0] == p.fruits[2] {
-		s1f += 21
-	}
-	s1f -= float64(p.ports)
-	s1f /= float64(f4)
-	s1int := int(math.Abs(s1f))
-	s1int %= 20
-
-	// Step 2
-	s2f := float64(f4 * f2)
-	if p.fruits[4] == p.fruits[6] && p.fruits[5] == p.fruits[7] {
-		s2f -= 54
-	}
-	if p.batteries != 0 {
-		s2f /= float64(p.batteries)
-	}
-	s2int := int(math.Abs(s2f))
-	s2int %= 20
-	s2int += 5
-
-	// Step 3
-	s3a := s1int + s2int
-	s3b := f1
-	s3c := f2
-	var s3d int
-	var s3str string
-	if p.batteries > 5 {
-		s3d = s3a + s3b - s3c
-		s3str = fmt.Sprintf("%d+%d-%d=%d", s3a, s3b, s3c, s3d)
-	} else {
-		s3d = s3a + s3b*s3c
-		s3str = fmt.Sprintf("%d+%d*%d=%d", s3a, s3b, s3c, s3d)
+// Id identifies the web conn in the admin API
+func (w *WebConn) Id() string {
+	return w.conn.RemoteAddr().String()
+}
+
+// StepResults holds whether each of the four steps in a solution was correct
+type StepResults [4]bool
+
+// Correct returns true if every step is correct
+func (s StepResults) Correct() bool {
+	return s[0] && s[1] && s[2] && s[3]
+}
+
+func (p *Puzzle) CheckSolution(sln protocol.PuzzleSolution) bool {
+	return p.CheckSolutionSteps(sln).Correct()
+}
+
+// Answers are the correct answers for each step of the puzzle
+type Answers struct {
+	Left    int
+	Right   int
+	Display string
+	// Status is either of the fruit text colours
+	Status [2]int
+}
+
+// RuleSet returns the rules used to check solutions
+func (p *Puzzle) RuleSet() RuleSet {
+	p.rulesLock.RLock()
+	defer p.rulesLock.RUnlock()
+	if p.rules == nil {
+		return DefaultRules
 	}
+	return p.rules
+}
+
+// Answers calculates the correct answers from the fruits and bomb details
+// using the selected rule set
+func (p *Puzzle) Answers() Answers {
+	return p.RuleSet().Answers(Bomb{Fruits: p.fruits, FruitText: p.cText, Batteries: p.batteries, Ports: p.ports})
+}
+
+// CheckSolutionSteps checks each step of the solution separately
+func (p *Puzzle) CheckSolutionSteps(sln protocol.PuzzleSolution) StepResults {
+	sln1 := sln.Left
+	sln2 := sln.Right
+	sln3 := sln.Display
+	sln4 := sln.Status
+	// Solution :: [1] Left fruit :: [2] Right fruit :: [3] Display content :: [4] Status light colour
+	a := p.Answers()
+	s1int, s2int, s3str := a.Left, a.Right, a.Display
 
 	p.log.Println("Correct Answers:")
 	p.log.Printf("  Step 1: %d\n", s1int)
 	p.log.Printf("  Step 2: %d\n", s2int)
 	p.log.Printf("  Step 3: %s\n", s3str)
-	p.log.Printf("  Step 4: %d or %d\n", p.cText[0], p.cText[1])
+	p.log.Printf("  Step 4: %d or %d\n", a.Status[0], a.Status[1])
 	p.log.Println("Your Answers:")
 	p.log.Printf("  Step 1: %d\n", sln1)
 	p.log.Printf("  Step 2: %d\n", sln2)
@@ -150,7 +161,7 @@ func (p *Puzzle) CheckSolution(sln []string) bool {
 	c1 := s1int == sln1
 	c2 := s2int == sln2
 	c3 := s3str == sln3
-	c4 := sln4 == p.cText[0] || sln4 == p.cText[1]
+	c4 := sln4 == a.Status[0] || sln4 == a.Status[1]
 
 	p.log.Println("Checking Answers:")
 	p.log.Printf("  Step 1: %v\n", c1)
@@ -158,60 +169,110 @@ func (p *Puzzle) CheckSolution(sln []string) bool {
 	p.log.Printf("  Step 3: %v\n", c3)
 	p.log.Printf("  Step 4: %v\n", c4)
 
-	return c1 && c2 && c3 && c4
+	return StepResults{c1, c2, c3, c4}
+}
+
+// LogId returns the id used to save the puzzle log of the kind
+func (p *Puzzle) LogId(kind LogKind) LogId {
+	return LogId{Date: p.date.Format(time.DateOnly), Code: p.code, Kind: kind}
 }
 
 func (p *Puzzle) checkKilled() bool {
 	return p.killed.Load()
 }
 
-func (p *Puzzle) SendMod(s string) {
+func (p *Puzzle) SendMod(packet protocol.Packet) {
 	if p.checkKilled() {
 		return
 	}
-	_ = p.modConn.WriteMessage(websocket.TextMessage, []byte(s))
+	p.modConnLock.Lock()
+	defer p.modConnLock.Unlock()
+	if p.modConn == nil {
+		// the module is detached so keep the packet until it resumes
+		p.modPending = append(p.modPending, packet)
+		return
+	}
+	p.modConn.Send(packet)
+}
+
+// closeMod closes the current module connection if there is one
+func (p *Puzzle) closeMod() {
+	p.modConnLock.Lock()
+	if p.modConn != nil {
+		_ = p.modConn.Close()
+	}
+	p.modConnLock.Unlock()
+}
+
+// detachModule removes the module connection if it is still the current one
+func (p *Puzzle) detachModule(c *Conn) bool {
+	p.modConnLock.Lock()
+	defer p.modConnLock.Unlock()
+	if p.modConn != c {
+		return false
+	}
+	p.modConn = nil
+	return true
+}
+
+// attachModule replaces the module connection and sends any pending packets
+func (p *Puzzle) attachModule(c *Conn) {
+	p.modConnLock.Lock()
+	defer p.modConnLock.Unlock()
+	if p.modConn != nil {
+		// the old connection hasn't noticed it was dropped yet
+		_ = p.modConn.Close()
+	}
+	p.modConn = c
+	for _, i := range p.modPending {
+		c.Send(i)
+	}
+	p.modPending = nil
 }
 
 func (p *Puzzle) RecvMod(s string) {
-	submatch := regPuzzleTwitchPlaysMode.FindStringSubmatch(s)
-	if submatch != nil {
-		p.twitchPlays = true
-		p.twitchId = submatch[1]
+	packet, err := protocol.Decode(protocol.FromModule, s)
+	if err != nil {
+		log.Printf("Invalid packet from module: %s\n", err)
+		if errors.Is(err, protocol.ErrUnknownPacket) {
+			p.metrics.ModuleUnknownPackets.Add(1)
+		}
 		return
 	}
-	submatch = regPuzzleActivateTwitchCode.FindStringSubmatch(s)
-	if submatch != nil {
+	switch packet := packet.(type) {
+	case protocol.Pong:
+	case protocol.PuzzleTwitchPlaysMode:
+		p.twitchPlays = true
+		p.twitchId = packet.TwitchId
+		p.event(Event{Type: EventTwitchPlays, TwitchId: packet.TwitchId})
+	case protocol.PuzzleTrainingMode:
+		p.training = true
+		p.log.Println("Training mode enabled")
+		p.event(Event{Type: EventTraining})
+		p.sendWebCapable(protocol.CapabilityTraining, protocol.PuzzleTrainingMode{})
+	case protocol.PuzzleActivateTwitchCode:
 		p.webConnLock.Lock()
 		for _, i := range p.webConns {
-			if i.tpCode == submatch[1] {
+			if i.tpCode == packet.Code {
 				i.tpDone = true
-				_ = i.conn.WriteMessage(websocket.TextMessage, []byte("PuzzleActivateTwitchPlays"))
+				i.conn.Send(protocol.PuzzleActivateTwitchPlays{})
+				p.metrics.TwitchActivations.Add(1)
+				p.event(Event{Type: EventTwitchActivated, Conn: i.name, TwitchCode: packet.Code})
 				break
 			}
 		}
 		p.webConnLock.Unlock()
-		return
-	}
-	submatch = regPuzzleFruits.FindStringSubmatch(s)
-	if submatch != nil {
-		p.fruits = [8]int{
-			mustParseInt(submatch[1]),
-			mustParseInt(submatch[2]),
-			mustParseInt(submatch[3]),
-			mustParseInt(submatch[4]),
-			mustParseInt(submatch[5]),
-			mustParseInt(submatch[6]),
-			mustParseInt(submatch[7]),
-			mustParseInt(submatch[8]),
-		}
+	case protocol.PuzzleFruits:
+		p.fruits = packet.Fruits
+		p.event(Event{Type: EventFruits, Fruits: &packet.Fruits})
 		f := [8]string{}
 		for i := range p.fruits {
 			f[i] = fruitNames[p.fruits[i]]
 		}
-		f1 := fruitNumbers[p.fruits[0]][p.fruits[2]]
-		f2 := fruitNumbers[p.fruits[1]][p.fruits[3]]
-		f3 := fruitNumbers[p.fruits[4]][p.fruits[6]]
-		f4 := fruitNumbers[p.fruits[5]][p.fruits[7]]
+		f1 := p.RuleSet().FruitNumber(p.fruits[0], p.fruits[2])
+		f2 := p.RuleSet().FruitNumber(p.fruits[1], p.fruits[3])
+		f3 := p.RuleSet().FruitNumber(p.fruits[4], p.fruits[6])
+		f4 := p.RuleSet().FruitNumber(p.fruits[5], p.fruits[7])
 		p.log.Printf(`Fruits: +---------------+------------+------------+--------+
         | Position      | Image      | Text       | Number |
         | Defuser Top   | %-10s | %-10s | %6d |
@@ -220,63 +281,165 @@ func (p *Puzzle) RecvMod(s string) {
         | Expert Right  | %-10s | %-10s | %6d |
         +---------------+------------+------------+--------+
 `, f[0], f[2], f1, f[1], f[3], f2, f[4], f[6], f3, f[5], f[7], f4)
-		return
-	}
-	submatch = regBombDetails.FindStringSubmatch(s)
-	if submatch != nil {
-		p.batteries = mustParseInt(submatch[1])
-		p.ports = mustParseInt(submatch[2])
+	case protocol.BombDetails:
+		p.batteries = packet.Batteries
+		p.ports = packet.Ports
 		p.log.Printf("Batteries: %d\n", p.batteries)
 		p.log.Printf("Ports: %d\n", p.ports)
-		return
+		p.event(Event{Type: EventBombDetails, Batteries: &packet.Batteries, Ports: &packet.Ports})
+	case protocol.PuzzleRuleSet:
+		p.selectRuleSet(packet.Id)
+	default:
+		log.Printf("Unexpected packet '%s' from module\n", s)
 	}
+}
 
-	log.Printf("Unknown packet '%s' from module\n", s)
+// selectRuleSet changes the rules used to check solutions, the rule set can't
+// change after the first solution attempt
+func (p *Puzzle) selectRuleSet(id string) {
+	rules := GetRuleSet(id)
+	p.rulesLock.Lock()
+	attempted := p.attempts.Load() > 0
+	if !attempted && rules != nil {
+		p.rules = rules
+	}
+	p.rulesLock.Unlock()
+	current := p.RuleSet()
+	switch {
+	case attempted:
+		p.log.Printf("Rule set %s ignored after a solution attempt\n", id)
+	case rules == nil:
+		p.log.Printf("Unknown rule set %s, using %s\n", id, current.Id())
+	default:
+		p.log.Printf("Rule set: %s\n", id)
+		p.event(Event{Type: EventRuleSet, RuleSet: id})
+	}
+	packet := protocol.PuzzleRuleSet{Id: current.Id()}
+	p.SendMod(packet)
+	p.sendWebCapable(protocol.CapabilityRuleSet, packet)
 }
 
-func (p *Puzzle) SendWebConns(s string) {
+func (p *Puzzle) SendWebConns(packet protocol.Packet) {
 	if p.checkKilled() {
 		return
 	}
 	p.webConnLock.RLock()
 	for _, i := range p.webConns {
-		_ = i.conn.WriteMessage(websocket.TextMessage, []byte(s))
+		i.conn.Send(packet)
+	}
+	p.webConnLock.RUnlock()
+}
+
+// sendWebCapable only sends the packet to web conns with the capability so
+// older web clients don't receive packets they can't handle
+func (p *Puzzle) sendWebCapable(capability string, packet protocol.Packet) {
+	if p.checkKilled() {
+		return
+	}
+	p.webConnLock.RLock()
+	for _, w := range p.webConns {
+		if w.features.Has(capability) {
+			w.conn.Send(packet)
+		}
 	}
 	p.webConnLock.RUnlock()
 }
 
 func (p *Puzzle) RecvWebConn(s string) {
-	submatch := regPuzzleSolution.FindStringSubmatch(s)
-	if submatch != nil {
+	packet, err := protocol.Decode(protocol.FromWeb, s)
+	if err != nil {
+		log.Printf("Invalid packet from web client: %s\n", err)
+		if errors.Is(err, protocol.ErrUnknownPacket) {
+			p.metrics.WebUnknownPackets.Add(1)
+		}
+		return
+	}
+	switch packet := packet.(type) {
+	case protocol.Pong:
+	case protocol.PuzzleSolution:
+		if p.solved.Load() {
+			// solutions sent before the module connection closes are ignored
+			p.log.Println("Solution ignored, puzzle already solved")
+			return
+		}
 		// log will only save after first solution check
 		p.saveLog.Store(true)
 
-		if p.CheckSolution(submatch) {
+		// the rule set can't be selected after this
+		p.rulesLock.Lock()
+		attempt := p.attempts.Add(1)
+		p.rulesLock.Unlock()
+		p.log.Printf("Solution attempt %d\n", attempt)
+
+		steps := p.CheckSolutionSteps(packet)
+		p.metrics.SolutionAttempts.Add(1)
+		p.metrics.AddSteps(steps)
+		correct := steps.Correct()
+		p.event(Event{
+			Type:    EventSolution,
+			Attempt: int(attempt),
+			Solution: &SolutionEvent{
+				Left:    packet.Left,
+				Right:   packet.Right,
+				Display: packet.Display,
+				Status:  packet.Status,
+			},
+			Steps:   &steps,
+			Correct: &correct,
+		})
+		if p.training {
+			p.sendWebCapable(protocol.CapabilityTraining, protocol.PuzzleStepResults{Steps: steps})
+		}
+
+		if correct {
+			if !p.solved.CompareAndSwap(false, true) {
+				// another web conn solved the puzzle first
+				return
+			}
+			p.metrics.PuzzlesSolved.Add(1)
+			p.event(Event{Type: EventSolved, Attempt: int(attempt)})
 			p.log.Println("Correct solution")
-			p.SendMod("PuzzleLog::CorrectSolution")
+			p.SendMod(protocol.PuzzleLog{Message: "CorrectSolution"})
 			p.log.Println("Sending solve")
-			p.SendMod("PuzzleComplete")
-			p.SendWebConns("PuzzleComplete")
+			p.SendMod(protocol.PuzzleComplete{})
+			p.SendWebConns(protocol.PuzzleComplete{})
 
 			go func() {
-				// force close module connection after 5 seconds
-				<-time.After(5 * time.Second)
-				_ = p.modConn.Close()
+				// force close module connection after the delay
+				<-time.After(p.solveCloseDelay)
+				p.closeMod()
 			}()
+			return
 		}
-		return
-	}
 
-	log.Printf("Unknown packet '%s' from web client\n", s)
+		p.log.Printf("Wrong solution (attempt %d)\n", attempt)
+		// older modules ignore wrong solutions
+		if p.modFeatures.Has(protocol.CapabilityStrike) {
+			p.SendMod(protocol.PuzzleLog{Message: "WrongSolution"})
+			p.log.Println("Sending strike")
+			p.SendMod(protocol.PuzzleStrike{})
+		}
+		p.sendWebCapable(protocol.CapabilityStrike, protocol.PuzzleWrongSolution{Attempt: int(attempt)})
+	default:
+		log.Printf("Unexpected packet '%s' from web client\n", s)
+	}
 }
 
-func (p *Puzzle) RemoveWebConn(c *websocket.Conn) {
+func (p *Puzzle) RemoveWebConn(c *Conn) {
 	p.webConnLock.Lock()
 	for i := range p.webConns {
 		if i >= len(p.webConns) {
 			break
 		}
 		if p.webConns[i].conn == c {
+			p.event(Event{Type: EventWebDisconnected, Conn: p.webConns[i].name})
+			// keep web conns which can rejoin
+			if w := p.webConns[i]; w.resumeToken != "" {
+				p.departed[w.resumeToken] = w
+				w.departTimer = time.AfterFunc(p.resumeGracePeriod, func() {
+					p.expireWebConn(w)
+				})
+			}
 			l := len(p.webConns)
 			p.webConns[i] = p.webConns[l-1]
 			p.webConns = p.webConns[:l-1]
@@ -285,12 +448,69 @@ func (p *Puzzle) RemoveWebConn(c *websocket.Conn) {
 	p.webConnLock.Unlock()
 }
 
+// expireWebConn forgets the departed web conn once the grace period is over
+// so its twitch plays code is no longer reserved
+func (p *Puzzle) expireWebConn(w *WebConn) {
+	p.webConnLock.Lock()
+	defer p.webConnLock.Unlock()
+	if p.departed[w.resumeToken] == w {
+		delete(p.departed, w.resumeToken)
+	}
+}
+
+// rejoinWebConn reattaches the web conn with the resume token to a new connection
+// run this inside the web conn lock
+func (p *Puzzle) rejoinWebConn(c *Conn, token string, features protocol.Features) *WebConn {
+	if w := p.departed[token]; w != nil {
+		delete(p.departed, token)
+		w.departTimer.Stop()
+		w.departTimer = nil
+		w.conn = c
+		w.features = features
+		p.webConns = append(p.webConns, w)
+		return w
+	}
+	for _, w := range p.webConns {
+		if w.resumeToken == token {
+			// the old connection hasn't noticed it was dropped yet
+			_ = w.conn.Close()
+			w.conn = c
+			w.features = features
+			return w
+		}
+	}
+	return nil
+}
+
+// sendWebState sends the current puzzle state to a newly connected web conn
+func (p *Puzzle) sendWebState(w *WebConn) {
+	w.conn.Send(protocol.PuzzleConnected{})
+	w.conn.Send(protocol.ExpertFruits{Fruits: [4]int{p.fruits[4], p.fruits[5], p.fruits[6], p.fruits[7]}})
+	w.conn.Send(protocol.PuzzleFruitText{Text: p.cText})
+	if p.training && w.features.Has(protocol.CapabilityTraining) {
+		w.conn.Send(protocol.PuzzleTrainingMode{})
+	}
+	if w.tpCode != "" {
+		if w.tpDone {
+			w.conn.Send(protocol.PuzzleActivateTwitchPlays{})
+		} else {
+			w.conn.Send(protocol.ExpertTwitchCode{TwitchId: p.twitchId, Code: w.tpCode})
+		}
+	}
+	if w.features.Has(protocol.CapabilityRuleSet) {
+		w.conn.Send(protocol.PuzzleRuleSet{Id: p.RuleSet().Id()})
+	}
+	if w.resumeToken != "" {
+		w.conn.Send(protocol.PuzzleResumeToken{Token: w.resumeToken})
+	}
+}
+
 func (p *Puzzle) Kill() {
 	if p.checkKilled() {
 		return
 	}
 	p.killed.Store(true)
-	_ = p.modConn.Close()
+	p.closeMod()
 	p.webConnLock.RLock()
 	for _, i := range p.webConns {
 		_ = i.conn.Close()
@@ -305,14 +525,12 @@ func (p *Puzzle) TPCodeExists(code string) bool {
 			return true
 		}
 	}
+	for _, i := range p.departed {
+		if i.tpCode == code {
+			// code is reserved for a web conn which might rejoin
+			return true
+		}
+	}
 	// code does not exist
 	return false
 }
-
-func mustParseInt(s string) int {
-	n, err := strconv.ParseInt(s, 10, 32)
-	if err != nil {
-		return 0
-	}
-	return int(n)
-}
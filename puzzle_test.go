@@ -4,8 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -26,8 +34,11 @@ type testStructCheckSolution struct {
 	cText      [2]int
 }
 
-func (t testStructCheckSolution) Parsed() []string {
-	return []string{t.Packet(), t.a, t.b, t.c, t.d}
+func (t testStructCheckSolution) Parsed() protocol.PuzzleSolution {
+	left, _ := strconv.Atoi(t.a)
+	right, _ := strconv.Atoi(t.b)
+	status, _ := strconv.Atoi(t.d)
+	return protocol.PuzzleSolution{Left: left, Right: right, Display: t.c, Status: status}
 }
 
 func (t testStructCheckSolution) Packet() string {
@@ -62,7 +73,7 @@ var testCheckSolution = []testStructCheckSolution{
 func TestPuzzle_CheckSolution(t *testing.T) {
 	for i, row := range testCheckSolution {
 		t.Run(fmt.Sprint(i), func(t *testing.T) {
-			logRaw := new(bytes.Buffer)
+			logRaw := new(logBuffer)
 			p := &Puzzle{
 				logRaw:    logRaw,
 				log:       log.New(logRaw, "", 0),
@@ -73,7 +84,7 @@ func TestPuzzle_CheckSolution(t *testing.T) {
 			}
 			a := p.CheckSolution(row.Parsed())
 			if row.out != a {
-				s := bufio.NewScanner(logRaw)
+				s := bufio.NewScanner(bytes.NewReader(logRaw.Bytes()))
 				for s.Scan() {
 					t.Log(s.Text())
 				}
@@ -82,3 +93,135 @@ func TestPuzzle_CheckSolution(t *testing.T) {
 		})
 	}
 }
+
+// testConnPair returns the server side and client side of a websocket connection
+func testConnPair(t *testing.T) (*Conn, *websocket.Conn) {
+	return testConnPairPing(t, PingConfig{})
+}
+
+func testConnPairPing(t *testing.T, ping PingConfig) (*Conn, *websocket.Conn) {
+	serverConn := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(rw, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConn <- c
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	c := NewConn(<-serverConn, ping)
+	t.Cleanup(func() { _ = c.Close() })
+	return c, client
+}
+
+func readText(t *testing.T, c *websocket.Conn) string {
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, b, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// strikeFeatures are used by clients which handle wrong solutions and training
+var strikeFeatures = protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityStrike, protocol.CapabilityTraining}}
+
+func TestPuzzle_RecvWebConn_WrongSolution(t *testing.T) {
+	modServer, modClient := testConnPair(t)
+	webServer, webClient := testConnPair(t)
+
+	p := NewPuzzle(modServer, false)
+	p.modFeatures = strikeFeatures
+	p.webConns = append(p.webConns, &WebConn{conn: webServer, features: strikeFeatures, tpDone: true})
+	p.batteries = 2
+	p.ports = 3
+	p.fruits = fruits1
+	p.cText = cText1
+
+	for i := 1; i <= 2; i++ {
+		p.RecvWebConn("PuzzleSolution::2::12::14+91*5=468::0")
+		assert.Equal(t, "PuzzleLog::WrongSolution", readText(t, modClient))
+		assert.Equal(t, "PuzzleStrike", readText(t, modClient))
+		assert.Equal(t, fmt.Sprintf("PuzzleWrongSolution::%d", i), readText(t, webClient))
+	}
+	assert.Equal(t, int32(2), p.attempts.Load())
+	assert.Contains(t, p.logRaw.String(), "Wrong solution (attempt 2)")
+}
+
+func TestPuzzle_RecvWebConn_TrainingMode(t *testing.T) {
+	modServer, modClient := testConnPair(t)
+	webServer, webClient := testConnPair(t)
+
+	p := NewPuzzle(modServer, false)
+	p.modFeatures = strikeFeatures
+	p.webConns = append(p.webConns, &WebConn{conn: webServer, features: strikeFeatures, tpDone: true})
+	p.RecvMod("BombDetails::2::3")
+	p.fruits = fruits1
+	p.cText = cText1
+
+	p.RecvMod("PuzzleTrainingMode")
+	assert.Equal(t, "PuzzleTrainingMode", readText(t, webClient))
+
+	p.RecvWebConn("PuzzleSolution::2::13::14+91*5=469::2")
+	assert.Equal(t, "PuzzleStepResults::1::0::1::0", readText(t, webClient))
+	assert.Equal(t, "PuzzleWrongSolution::1", readText(t, webClient))
+	assert.Equal(t, "PuzzleLog::WrongSolution", readText(t, modClient))
+
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	assert.Equal(t, "PuzzleStepResults::1::1::1::1", readText(t, webClient))
+	assert.Equal(t, "PuzzleComplete", readText(t, webClient))
+}
+
+func TestPuzzle_RecvWebConn_AfterSolve(t *testing.T) {
+	modServer, modClient := testConnPair(t)
+	webServer, webClient := testConnPair(t)
+
+	p := NewPuzzle(modServer, false)
+	p.solveCloseDelay = time.Minute
+	p.webConns = append(p.webConns, &WebConn{conn: webServer, tpDone: true})
+	p.batteries = 2
+	p.ports = 3
+	p.fruits = fruits1
+	p.cText = cText1
+
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	assert.Equal(t, "PuzzleLog::CorrectSolution", readText(t, modClient))
+	assert.Equal(t, "PuzzleComplete", readText(t, modClient))
+	assert.Equal(t, "PuzzleComplete", readText(t, webClient))
+
+	// late solutions don't strike or solve the module again
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=468::0")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	p.SendMod(protocol.Ping{})
+	assert.Equal(t, "ping", readText(t, modClient))
+	assert.Equal(t, int32(1), p.attempts.Load())
+	assert.Equal(t, uint64(1), p.metrics.SolutionAttempts.Load())
+	assert.Equal(t, uint64(1), p.metrics.PuzzlesSolved.Load())
+	assert.Equal(t, 1, strings.Count(p.events.String(), `"type":"solved"`))
+}
+
+func TestPuzzle_RecvWebConn_Legacy(t *testing.T) {
+	modServer, modClient := testConnPair(t)
+	webServer, webClient := testConnPair(t)
+
+	p := NewPuzzle(modServer, false)
+	p.modFeatures = protocol.Features{Version: 1}
+	p.webConns = append(p.webConns, &WebConn{conn: webServer, features: protocol.Features{Version: 1}, tpDone: true})
+	p.RecvMod("BombDetails::2::3")
+	p.fruits = fruits1
+	p.cText = cText1
+
+	// older clients don't get training results, strikes or rejections
+	p.RecvMod("PuzzleTrainingMode")
+	p.RecvWebConn("PuzzleSolution::2::13::14+91*5=469::2")
+	assert.Equal(t, int32(1), p.attempts.Load())
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=469::0")
+	assert.Equal(t, "PuzzleLog::CorrectSolution", readText(t, modClient))
+	assert.Equal(t, "PuzzleComplete", readText(t, webClient))
+}
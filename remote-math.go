@@ -1,20 +1,18 @@
 package ktanemod_remote_math_server
 
 import (
+	"crypto/ed25519"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
 	"log"
 	"math/rand"
-	"os"
-	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var regPuzzleConnect = regexp.MustCompile("^PuzzleConnect::([a-zA-Z]{6})$")
-
 const idBytes = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 type RemoteMath struct {
@@ -23,98 +21,268 @@ type RemoteMath struct {
 	puzzles    map[string]*Puzzle
 	respawn    map[string]*Puzzle
 	puzzleStop bool
+	draining   bool
+	drained    chan struct{}
 	pingStop   chan struct{}
-	debug      bool
-	logDir     string
+	conf       *atomic.Pointer[Config]
+	metrics    *Metrics
+	logs       LogStore
+	index      *LogIndex
+	signKey    ed25519.PrivateKey
 }
 
-func NewRemoteMath(random *rand.Rand, logDir string, debug bool) *RemoteMath {
+func NewRemoteMath(random *rand.Rand, conf *Config, logs LogStore) *RemoteMath {
 	r := &RemoteMath{
 		rId:        random,
 		puzzleLock: new(sync.RWMutex),
 		puzzles:    make(map[string]*Puzzle),
-		debug:      debug,
-		logDir:     logDir,
+		respawn:    make(map[string]*Puzzle),
+		conf:       new(atomic.Pointer[Config]),
+		metrics:    new(Metrics),
+		logs:       logs,
+		index:      NewLogIndex(),
 	}
+	r.conf.Store(conf)
 	return r
 }
 
+// SetConfig replaces the config used for new puzzles and saving logs, the log
+// index is rebuilt when the log directory changes
+func (r *RemoteMath) SetConfig(conf *Config) {
+	old := r.conf.Swap(conf)
+	fs, ok := r.logs.(*FSLogStore)
+	if !ok || old.LogDir == conf.LogDir {
+		return
+	}
+	fs.SetDir(conf.LogDir)
+	r.index.Clear()
+	if err := r.index.Rebuild(r.logs); err != nil {
+		log.Printf("[RemoteMath] Log index error: %s\n", err)
+	}
+}
+
+// Drain stops new puzzles from being created and tells every client the
+// server is shutting down, then waits for the active puzzles to finish
+//
+// Returns false if puzzles are still active after the timeout.
+func (r *RemoteMath) Drain(timeout time.Duration) bool {
+	r.puzzleLock.Lock()
+	if r.puzzleStop || r.draining {
+		r.puzzleLock.Unlock()
+		return false
+	}
+	r.draining = true
+	active := r.activePuzzles()
+	drained := make(chan struct{})
+	if len(active) == 0 {
+		close(drained)
+	} else {
+		r.drained = drained
+	}
+	r.puzzleLock.Unlock()
+
+	log.Printf("[RemoteMath] Draining %d puzzles\n", len(active))
+	for _, p := range active {
+		p.SendNotice(fmt.Sprintf("The server is shutting down, finish the current bomb within %s", timeout))
+	}
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close kills every remaining puzzle and saves their logs
 func (r *RemoteMath) Close() {
 	r.puzzleLock.Lock()
-	defer r.puzzleLock.Unlock()
 	if r.puzzleStop {
+		r.puzzleLock.Unlock()
 		return
 	}
 	r.puzzleStop = true
-	for _, i := range r.puzzles {
-		if i != nil {
-			i.log.Println("Server shutdown")
-			go i.Kill()
+	active := r.activePuzzles()
+	for _, p := range r.respawn {
+		if p.detachTimer != nil {
+			p.detachTimer.Stop()
+			p.detachTimer = nil
+		}
+	}
+	r.respawn = make(map[string]*Puzzle)
+	r.puzzleLock.Unlock()
+
+	for _, p := range active {
+		p.log.Println("Server shutdown")
+		// keep the log of puzzles interrupted by the shutdown
+		p.saveLog.Store(true)
+		r.ClosePuzzle(p)
+	}
+}
+
+// activePuzzles returns every puzzle which hasn't been closed
+// run this inside the lock
+func (r *RemoteMath) activePuzzles() []*Puzzle {
+	active := make([]*Puzzle, 0, len(r.puzzles))
+	for _, p := range r.puzzles {
+		if p != nil {
+			active = append(active, p)
 		}
 	}
+	return active
+}
+
+// Stopping returns true once Close has been called
+func (r *RemoteMath) Stopping() bool {
+	r.puzzleLock.RLock()
+	defer r.puzzleLock.RUnlock()
+	return r.puzzleStop
 }
 
-func (r *RemoteMath) CreatePuzzle(conn *websocket.Conn) *Puzzle {
-	p := NewPuzzle(conn, r.debug)
+func (r *RemoteMath) CreatePuzzle(conn *Conn, features protocol.Features) *Puzzle {
+	conf := r.conf.Load()
+	p := NewPuzzle(conn, conf.Debug)
+	p.solveCloseDelay = conf.Puzzle.SolveCloseDelay
+	p.resumeGracePeriod = conf.Puzzle.ResumeGracePeriod
+	p.metrics = r.metrics
+	p.modFeatures = features
 	p.cText = [2]int{r.rId.Intn(6), r.rId.Intn(6)}
+	if features.Has(protocol.CapabilityResume) {
+		p.resumeToken = MakeToken()
+	}
 
 	// make sure puzzle code is only used once at a time
 	r.puzzleLock.Lock()
-	if r.puzzleStop {
+	if r.puzzleStop || r.draining {
 		r.puzzleLock.Unlock()
 		return nil
 	}
 	p.code = r.genPuzzleCode()
 	r.puzzles[p.code] = p
 	r.puzzleLock.Unlock()
+	r.metrics.PuzzlesCreated.Add(1)
 	p.log.Printf("Module ID: %s\n", p.code)
+	p.log.Printf("Module protocol: %s\n", features)
+	p.event(Event{Type: EventCreated, Code: p.code, Protocol: features.String(), FruitText: &p.cText})
 	return p
 }
 
 func (r *RemoteMath) ClosePuzzle(puzzle *Puzzle) {
 	r.puzzleLock.Lock()
-	if r.puzzleStop {
+	if r.puzzles[puzzle.code] != puzzle {
+		// the puzzle was already closed
 		r.puzzleLock.Unlock()
 		return
 	}
 	r.puzzles[puzzle.code] = nil
+	if r.drained != nil && len(r.activePuzzles()) == 0 {
+		close(r.drained)
+		r.drained = nil
+	}
 	r.puzzleLock.Unlock()
 	puzzle.Kill()
+	puzzle.event(Event{Type: EventClosed})
+	if !puzzle.solved.Load() {
+		r.metrics.PuzzlesAbandoned.Add(1)
+	}
 
 	// now the puzzle is finished, save the log
 	if puzzle.saveLog.Load() {
-		logPath := filepath.Join(r.logDir, puzzle.date.Format(time.DateOnly))
-		err := os.Mkdir(logPath, os.ModePerm)
-		if err != nil && !os.IsExist(err) {
-			log.Printf("[RemoteMath] Failed to create log directory '%s': %s\n", logPath, err)
-			return
+		if err := r.SaveLog(puzzle); err != nil {
+			r.metrics.LogSaveFailures.Add(1)
+			log.Printf("[RemoteMath] %s\n", err)
 		}
-		logFile := filepath.Join(logPath, puzzle.code+".log")
-		create, err := os.Create(logFile)
-		if err != nil {
-			log.Printf("[RemoteMath] Failed to create log file '%s': %s\n", logFile, err)
-			return
-		}
-		_, err = puzzle.logRaw.WriteTo(create)
-		if err != nil {
-			log.Printf("[RemoteMath] Failed to write log file '%s': %s\n", logFile, err)
+	}
+}
+
+// SaveLog writes the current contents of the puzzle log and event log to the
+// LogStore
+func (r *RemoteMath) SaveLog(puzzle *Puzzle) error {
+	if err := r.saveSigned(puzzle.LogId(LogText), puzzle.logRaw.Bytes()); err != nil {
+		return err
+	}
+	if err := r.saveSigned(puzzle.LogId(LogEvents), puzzle.events.Bytes()); err != nil {
+		return err
+	}
+	r.indexPuzzle(puzzle)
+	return nil
+}
+
+// DetachPuzzle is called when the module connection closes, puzzles which can
+// be resumed are kept for the grace period before being closed
+func (r *RemoteMath) DetachPuzzle(puzzle *Puzzle, c *Conn) {
+	if !puzzle.detachModule(c) {
+		// the module already resumed on a new connection
+		return
+	}
+	puzzle.event(Event{Type: EventModuleDetached})
+	if puzzle.resumeToken == "" || puzzle.solved.Load() || puzzle.checkKilled() {
+		r.ClosePuzzle(puzzle)
+		return
+	}
+
+	r.puzzleLock.Lock()
+	defer r.puzzleLock.Unlock()
+	if r.puzzleStop {
+		// Close already closed the puzzle
+		return
+	}
+	puzzle.log.Println("Module disconnected, waiting for resume")
+	r.respawn[puzzle.code] = puzzle
+	puzzle.detachTimer = time.AfterFunc(r.conf.Load().Puzzle.ResumeGracePeriod, func() {
+		r.puzzleLock.Lock()
+		if r.respawn[puzzle.code] != puzzle {
+			r.puzzleLock.Unlock()
 			return
 		}
-	}
+		delete(r.respawn, puzzle.code)
+		r.puzzleLock.Unlock()
+
+		puzzle.log.Println("Module did not resume in time")
+		r.ClosePuzzle(puzzle)
+	})
 }
 
-func (r *RemoteMath) ConnectPuzzle(c *websocket.Conn, s string) *Puzzle {
-	match := regPuzzleConnect.FindStringSubmatch(s)
-	if match == nil {
+// ResumePuzzle reattaches a module connection to an existing puzzle
+func (r *RemoteMath) ResumePuzzle(c *Conn, resume protocol.PuzzleResume) *Puzzle {
+	code := strings.ToUpper(resume.Code)
+
+	r.puzzleLock.Lock()
+	if r.puzzleStop {
+		r.puzzleLock.Unlock()
+		return nil
+	}
+	// the puzzle might still be attached if the old connection hasn't timed out
+	p := r.respawn[code]
+	if p == nil {
+		p = r.puzzles[code]
+	}
+	if p == nil || !checkToken(p.resumeToken, resume.Token) {
+		r.puzzleLock.Unlock()
 		return nil
 	}
+	if p.detachTimer != nil {
+		p.detachTimer.Stop()
+		p.detachTimer = nil
+	}
+	delete(r.respawn, code)
+	r.puzzleLock.Unlock()
+
+	p.log.Println("Module resumed")
+	p.event(Event{Type: EventModuleResumed, Protocol: p.modFeatures.String()})
+	c.Send(protocol.ClientSelected{Features: p.modFeatures})
+	c.Send(protocol.PuzzleResumed{})
+	p.attachModule(c)
+	return p
+}
+
+func (r *RemoteMath) ConnectPuzzle(c *Conn, connect protocol.PuzzleConnect, features protocol.Features) *Puzzle {
 	r.puzzleLock.RLock()
 	defer r.puzzleLock.RUnlock()
 	if r.puzzleStop {
 		return nil
 	}
 
-	code := strings.ToUpper(match[1])
+	code := strings.ToUpper(connect.Code)
 
 	// get puzzle
 	p := r.puzzles[code]
@@ -135,21 +303,52 @@ func (r *RemoteMath) ConnectPuzzle(c *websocket.Conn, s string) *Puzzle {
 	}
 
 	// add new web conn
-	p.webConns = append(p.webConns, &WebConn{
-		conn:   c,
-		tpDone: tpCode == "",
-		tpCode: tpCode,
-	})
+	p.experts++
+	w := &WebConn{
+		conn:     c,
+		name:     "expert-" + strconv.Itoa(p.experts),
+		features: features,
+		tpDone:   tpCode == "",
+		tpCode:   tpCode,
+	}
+	if features.Has(protocol.CapabilityResume) {
+		w.resumeToken = MakeToken()
+	}
+	p.webConns = append(p.webConns, w)
 	p.webConnLock.Unlock()
+	p.event(Event{Type: EventWebConnected, Conn: w.name, Protocol: features.String(), TwitchCode: tpCode})
 
-	_ = c.WriteMessage(websocket.TextMessage, []byte("PuzzleConnected"))
-	_ = c.WriteMessage(websocket.TextMessage, []byte("PuzzleFruits::"+fmt.Sprintf("%d::%d::%d::%d", p.fruits[4], p.fruits[5], p.fruits[6], p.fruits[7])))
-	_ = c.WriteMessage(websocket.TextMessage, []byte("PuzzleFruitText::"+fmt.Sprintf("%d::%d", p.cText[0], p.cText[1])))
 	if tpCode != "" {
-		p.SendMod("PuzzleTwitchCode::" + tpCode)
-		_ = c.WriteMessage(websocket.TextMessage, []byte("PuzzleTwitchCode::"+p.twitchId+"::"+tpCode))
+		p.SendMod(protocol.PuzzleTwitchCode{Code: tpCode})
+	}
+	p.sendWebState(w)
+
+	return p
+}
+
+// RejoinPuzzle reattaches a web client to the puzzle with its previous state
+func (r *RemoteMath) RejoinPuzzle(c *Conn, rejoin protocol.PuzzleRejoin, features protocol.Features) *Puzzle {
+	r.puzzleLock.RLock()
+	defer r.puzzleLock.RUnlock()
+	if r.puzzleStop {
+		return nil
+	}
+
+	p := r.puzzles[strings.ToUpper(rejoin.Code)]
+	if p == nil {
+		return nil
+	}
+
+	p.webConnLock.Lock()
+	w := p.rejoinWebConn(c, rejoin.Token, features)
+	p.webConnLock.Unlock()
+	if w == nil {
+		return nil
 	}
 
+	p.log.Println("Web client rejoined")
+	p.event(Event{Type: EventWebRejoined, Conn: w.name, Protocol: features.String()})
+	p.sendWebState(w)
 	return p
 }
 
@@ -0,0 +1,144 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var resumeFeatures = protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityResume}}
+
+func TestRemoteMath_ResumePuzzle(t *testing.T) {
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), NewMemoryLogStore())
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, resumeFeatures)
+	p.RecvMod("BombDetails::2::3")
+	assert.Len(t, p.resumeToken, protocol.TokenLength)
+
+	r.DetachPuzzle(p, modServer)
+	assert.Equal(t, p, r.respawn[p.code])
+
+	// packets sent while detached are kept until the module resumes
+	p.SendMod(protocol.PuzzleStrike{})
+
+	modServer2, modClient2 := testConnPair(t)
+	assert.Nil(t, r.ResumePuzzle(modServer2, protocol.PuzzleResume{Code: p.code, Token: MakeToken()}))
+	assert.Equal(t, p, r.ResumePuzzle(modServer2, protocol.PuzzleResume{Code: p.code, Token: p.resumeToken}))
+	assert.NotContains(t, r.respawn, p.code)
+	assert.Equal(t, "ClientSelected::2::resume", readText(t, modClient2))
+	assert.Equal(t, "PuzzleResumed", readText(t, modClient2))
+	assert.Equal(t, "PuzzleStrike", readText(t, modClient2))
+	assert.Equal(t, 2, p.batteries)
+
+	// the old connection closing doesn't detach the resumed module
+	r.DetachPuzzle(p, modServer)
+	assert.NotContains(t, r.respawn, p.code)
+	assert.False(t, p.checkKilled())
+}
+
+func TestRemoteMath_DetachPuzzle_NoResume(t *testing.T) {
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), NewMemoryLogStore())
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	assert.Empty(t, p.resumeToken)
+
+	r.DetachPuzzle(p, modServer)
+	assert.NotContains(t, r.respawn, p.code)
+	assert.True(t, p.checkKilled())
+}
+
+func TestRemoteMath_RejoinPuzzle(t *testing.T) {
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), NewMemoryLogStore())
+	modServer, modClient := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.RecvMod("PuzzleTwitchPlaysMode::42")
+
+	webServer, webClient := testConnPair(t)
+	assert.Equal(t, p, r.ConnectPuzzle(webServer, protocol.PuzzleConnect{Code: p.code}, resumeFeatures))
+	assert.Equal(t, "PuzzleConnected", readText(t, webClient))
+	assert.Equal(t, "PuzzleFruits::0::0::0::0", readText(t, webClient))
+	readText(t, webClient)
+	twitchCode := readText(t, webClient)
+	assert.Regexp(t, "^PuzzleTwitchCode::42::[0-9]{3}$", twitchCode)
+	token := readText(t, webClient)
+	assert.Regexp(t, "^PuzzleResumeToken::[0-9a-f]{32}$", token)
+	assert.Equal(t, "PuzzleTwitchCode::"+twitchCode[len(twitchCode)-3:], readText(t, modClient))
+
+	p.RemoveWebConn(webServer)
+	assert.Len(t, p.webConns, 0)
+
+	// the twitch code is still reserved and can be activated after rejoining
+	webServer2, webClient2 := testConnPair(t)
+	rejoin := protocol.PuzzleRejoin{Code: p.code, Token: token[len("PuzzleResumeToken::"):]}
+	assert.Nil(t, r.RejoinPuzzle(webServer2, protocol.PuzzleRejoin{Code: p.code, Token: MakeToken()}, resumeFeatures))
+	assert.Equal(t, p, r.RejoinPuzzle(webServer2, rejoin, resumeFeatures))
+	assert.Equal(t, "PuzzleConnected", readText(t, webClient2))
+	readText(t, webClient2)
+	readText(t, webClient2)
+	assert.Equal(t, twitchCode, readText(t, webClient2))
+	assert.Equal(t, token, readText(t, webClient2))
+
+	p.RecvMod("PuzzleActivateTwitchCode::" + twitchCode[len(twitchCode)-3:])
+	assert.Equal(t, "PuzzleActivateTwitchPlays", readText(t, webClient2))
+}
+
+func TestRemoteMath_Drain(t *testing.T) {
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), NewMemoryLogStore())
+	modServer, modClient := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityNotice}})
+
+	// the drain finishes when the last puzzle closes
+	done := make(chan bool)
+	go func() { done <- r.Drain(time.Minute) }()
+	assert.Equal(t, "ServerNotice::The server is shutting down, finish the current bomb within 1m0s", readText(t, modClient))
+	modServer2, _ := testConnPair(t)
+	assert.Nil(t, r.CreatePuzzle(modServer2, protocol.Features{Version: 1}))
+	r.ClosePuzzle(p)
+	assert.True(t, <-done)
+}
+
+func TestRemoteMath_Close(t *testing.T) {
+	logs := NewMemoryLogStore()
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), logs)
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	modServer2, _ := testConnPair(t)
+	p2 := r.CreatePuzzle(modServer2, resumeFeatures)
+	r.DetachPuzzle(p2, modServer2)
+
+	assert.False(t, r.Drain(10*time.Millisecond))
+	r.Close()
+	assert.True(t, p.checkKilled())
+	assert.Empty(t, r.respawn)
+
+	// logs of interrupted puzzles are saved
+	f, _, err := logs.Open(p.LogId(LogText))
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(f)
+	assert.Contains(t, string(b), "Server shutdown")
+	_, _, err = logs.Open(p2.LogId(LogEvents))
+	assert.NoError(t, err)
+}
+
+func TestPuzzle_RemoveWebConn_Expire(t *testing.T) {
+	modServer, _ := testConnPair(t)
+	webServer, _ := testConnPair(t)
+	p := NewPuzzle(modServer, false)
+	p.resumeGracePeriod = 50 * time.Millisecond
+	p.webConns = append(p.webConns, &WebConn{conn: webServer, resumeToken: MakeToken(), tpCode: "123"})
+
+	p.RemoveWebConn(webServer)
+	p.webConnLock.RLock()
+	assert.True(t, p.TPCodeExists("123"))
+	p.webConnLock.RUnlock()
+
+	// the twitch code is released when the web conn doesn't rejoin in time
+	assert.Eventually(t, func() bool {
+		p.webConnLock.RLock()
+		defer p.webConnLock.RUnlock()
+		return len(p.departed) == 0 && !p.TPCodeExists("123")
+	}, time.Second, 10*time.Millisecond)
+}
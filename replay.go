@@ -0,0 +1,120 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"io"
+)
+
+// ReplayResult compares the recorded solution attempts of a puzzle with the
+// current rules
+type ReplayResult struct {
+	Code     string
+	Attempts []ReplayAttempt
+}
+
+// ReplayAttempt is a single solution attempt checked again
+type ReplayAttempt struct {
+	Attempt  int
+	Solution SolutionEvent
+	// Recorded is the result saved in the event log
+	Recorded StepResults
+	// Replayed is the result of checking the solution again
+	Replayed StepResults
+}
+
+// Matches returns true if the replayed result is the same as the recorded one
+func (a ReplayAttempt) Matches() bool {
+	return a.Recorded == a.Replayed
+}
+
+// Mismatches returns the attempts where the current rules give a different result
+func (r ReplayResult) Mismatches() []ReplayAttempt {
+	var m []ReplayAttempt
+	for _, i := range r.Attempts {
+		if !i.Matches() {
+			m = append(m, i)
+		}
+	}
+	return m
+}
+
+// Replay rebuilds the puzzle from the events and checks every recorded
+// solution again
+func Replay(events []Event) (ReplayResult, error) {
+	p := NewPuzzle(nil, false)
+	p.log.SetOutput(io.Discard)
+	var result ReplayResult
+	var created, fruits, details bool
+	for _, e := range events {
+		switch e.Type {
+		case EventCreated:
+			if e.FruitText == nil {
+				return result, errors.New("created event is missing the fruit text")
+			}
+			if !validFruits(e.FruitText[:]) {
+				return result, fmt.Errorf("created event has invalid fruit text %v", *e.FruitText)
+			}
+			p.code = e.Code
+			p.cText = *e.FruitText
+			result.Code = e.Code
+			created = true
+		case EventFruits:
+			if e.Fruits == nil {
+				return result, errors.New("fruits event is missing the fruits")
+			}
+			if !validFruits(e.Fruits[:]) {
+				return result, fmt.Errorf("fruits event has invalid fruits %v", *e.Fruits)
+			}
+			p.fruits = *e.Fruits
+			fruits = true
+		case EventBombDetails:
+			if e.Batteries == nil || e.Ports == nil {
+				return result, errors.New("bomb details event is missing the batteries or ports")
+			}
+			p.batteries = *e.Batteries
+			p.ports = *e.Ports
+			details = true
+		case EventRuleSet:
+			rules := GetRuleSet(e.RuleSet)
+			if rules == nil {
+				return result, fmt.Errorf("unknown rule set '%s'", e.RuleSet)
+			}
+			p.rules = rules
+		case EventSolution:
+			if e.Solution == nil || e.Steps == nil {
+				return result, fmt.Errorf("solution event for attempt %d is missing the solution or steps", e.Attempt)
+			}
+			if !created || !fruits || !details {
+				return result, fmt.Errorf("solution attempt %d happened before the puzzle was set up", e.Attempt)
+			}
+			result.Attempts = append(result.Attempts, ReplayAttempt{
+				Attempt:  e.Attempt,
+				Solution: *e.Solution,
+				Recorded: *e.Steps,
+				Replayed: p.CheckSolutionSteps(protocol.PuzzleSolution{
+					Left:    e.Solution.Left,
+					Right:   e.Solution.Right,
+					Display: e.Solution.Display,
+					Status:  e.Solution.Status,
+				}),
+			})
+		}
+	}
+	if !created {
+		return result, errors.New("missing created event")
+	}
+	return result, nil
+}
+
+// validFruits checks every fruit is in range like the protocol decoder does,
+// edited event logs could otherwise look up fruits which don't exist
+func validFruits(fruits []int) bool {
+	for _, i := range fruits {
+		if i < 0 || i >= len(fruitNames) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	logs := NewMemoryLogStore()
+	r := NewRemoteMath(rand.New(rand.NewSource(1)), testConfig(t), logs)
+	modServer, _ := testConnPair(t)
+	p := r.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.RecvMod("PuzzleFruits::1::3::4::1::0::3::5::2")
+	p.RecvMod("BombDetails::2::3")
+	p.RecvWebConn("PuzzleSolution::2::13::14+91*5=469::2")
+	p.RecvWebConn("PuzzleSolution::2::12::14+91*5=468::0")
+	r.ClosePuzzle(p)
+
+	f, _, err := logs.Open(p.LogId(LogEvents))
+	assert.NoError(t, err)
+	events, err := ReadEvents(f)
+	assert.NoError(t, err)
+
+	result, err := Replay(events)
+	assert.NoError(t, err)
+	assert.Equal(t, p.code, result.Code)
+	assert.Len(t, result.Attempts, 2)
+	assert.Empty(t, result.Mismatches())
+	assert.Equal(t, SolutionEvent{Left: 2, Right: 12, Display: "14+91*5=468", Status: 0}, result.Attempts[1].Solution)
+
+	// a rule change shows up as a mismatch
+	for i := range events {
+		if events[i].Type == EventSolution && events[i].Attempt == 2 {
+			events[i].Steps = &StepResults{true, true, true, true}
+		}
+	}
+	result, err = Replay(events)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Mismatches(), 1) {
+		assert.Equal(t, 2, result.Mismatches()[0].Attempt)
+	}
+
+	_, err = Replay(events[1:])
+	assert.Error(t, err)
+
+	// logs using a rule set which is no longer registered can't be replayed
+	unknown := append([]Event{events[0], {Type: EventRuleSet, RuleSet: "missing"}}, events[1:]...)
+	_, err = Replay(unknown)
+	assert.ErrorContains(t, err, "unknown rule set 'missing'")
+
+	// tampered fruits are reported instead of being looked up
+	badFruits := append([]Event(nil), events...)
+	assert.Equal(t, EventFruits, badFruits[1].Type)
+	badFruits[1].Fruits = &[8]int{1, 3, 4, 1, 0, 3, 5, 6}
+	_, err = Replay(badFruits)
+	assert.ErrorContains(t, err, "fruits event has invalid fruits")
+	badText := append([]Event(nil), events...)
+	badText[0].FruitText = &[2]int{-1, 0}
+	_, err = Replay(badText)
+	assert.ErrorContains(t, err, "created event has invalid fruit text")
+}
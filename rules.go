@@ -0,0 +1,169 @@
+package ktanemod_remote_math_server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefaultRuleSetId selects the rules from the original manual
+const DefaultRuleSetId = "default"
+
+// RuleSet calculates the correct answers for a puzzle, each manual revision or
+// variant has its own rule set
+type RuleSet interface {
+	// Id is sent by the module to select the rule set
+	Id() string
+	// FruitNumber returns the number shown in the manual for the fruit image
+	// and text
+	FruitNumber(image, text int) int
+	// Answers calculates the correct answers for the bomb
+	Answers(b Bomb) Answers
+}
+
+// Bomb is the puzzle setup sent by the module
+type Bomb struct {
+	Fruits    [8]int
+	FruitText [2]int
+	Batteries int
+	Ports     int
+}
+
+// StandardRules follow the steps of the manual with adjustable numbers
+type StandardRules struct {
+	RuleId string `json:"rule_set"`
+	// FruitNumbers is indexed by the fruit image then the fruit text
+	FruitNumbers [6][6]int `json:"fruit_numbers"`
+	// Step1Multiplier multiplies the defuser's top fruit number in step 1
+	Step1Multiplier int `json:"step1_multiplier"`
+	// Step1Matching is added in step 1 when the defuser's top fruit image and
+	// text match
+	Step1Matching int `json:"step1_matching"`
+	// Step2Matching is subtracted in step 2 when both expert fruits have
+	// matching images and text
+	Step2Matching int `json:"step2_matching"`
+	// Modulo limits the step 1 and 2 answers
+	Modulo int `json:"modulo"`
+	// Step2Offset is added to the step 2 answer
+	Step2Offset int `json:"step2_offset"`
+	// BatteryThreshold switches step 3 to subtraction when the bomb has more
+	// batteries than this
+	BatteryThreshold int `json:"battery_threshold"`
+}
+
+// DefaultRules are the rules from the original manual
+var DefaultRules = &StandardRules{
+	RuleId: DefaultRuleSetId,
+	FruitNumbers: [6][6]int{
+		{88, 1, 48, 75, 31, 8},
+		{84, 42, 62, 21, 91, 17},
+		{56, 29, 12, 53, 11, 81},
+		{32, 5, 19, 38, 25, 64},
+		{44, 61, 20, 92, 13, 4},
+		{34, 50, 87, 22, 54, 19},
+	},
+	Step1Multiplier:  13,
+	Step1Matching:    21,
+	Step2Matching:    54,
+	Modulo:           20,
+	Step2Offset:      5,
+	BatteryThreshold: 5,
+}
+
+func (s *StandardRules) Id() string { return s.RuleId }
+
+func (s *StandardRules) FruitNumber(image, text int) int {
+	return s.FruitNumbers[image][text]
+}
+
+func (s *StandardRules) Answers(b Bomb) Answers {
+	// Fruit numbers
+	/* f1 = defuser's top
+	 * f2 = defuser's right
+	 * f3 = expert's left
+	 * f4 = expert's right
+	 */
+
+	f1 := s.FruitNumber(b.Fruits[0], b.Fruits[2]) // top defuser
+	f2 := s.FruitNumber(b.Fruits[1], b.Fruits[3]) // right defuser
+	f4 := s.FruitNumber(b.Fruits[5], b.Fruits[7]) // right expert
+
+	// Step 1
+	s1f := float64(f1 * s.Step1Multiplier)
+	if b.Fruits[0] == b.Fruits[2] {
+		s1f += float64(s.Step1Matching)
+	}
+	s1f -= float64(b.Ports)
+	s1f /= float64(f4)
+	s1int := int(math.Abs(s1f))
+	s1int %= s.Modulo
+
+	// Step 2
+	s2f := float64(f4 * f2)
+	if b.Fruits[4] == b.Fruits[6] && b.Fruits[5] == b.Fruits[7] {
+		s2f -= float64(s.Step2Matching)
+	}
+	if b.Batteries != 0 {
+		s2f /= float64(b.Batteries)
+	}
+	s2int := int(math.Abs(s2f))
+	s2int %= s.Modulo
+	s2int += s.Step2Offset
+
+	// Step 3
+	s3a := s1int + s2int
+	s3b := f1
+	s3c := f2
+	var s3d int
+	var s3str string
+	if b.Batteries > s.BatteryThreshold {
+		s3d = s3a + s3b - s3c
+		s3str = fmt.Sprintf("%d+%d-%d=%d", s3a, s3b, s3c, s3d)
+	} else {
+		s3d = s3a + s3b*s3c
+		s3str = fmt.Sprintf("%d+%d*%d=%d", s3a, s3b, s3c, s3d)
+	}
+
+	return Answers{Left: s1int, Right: s2int, Display: s3str, Status: b.FruitText}
+}
+
+var (
+	ruleSetLock = new(sync.RWMutex)
+	ruleSets    = map[string]RuleSet{DefaultRuleSetId: DefaultRules}
+)
+
+// RegisterRuleSet makes the rule set available to modules, registering an id
+// again replaces the rule set
+func RegisterRuleSet(r RuleSet) {
+	ruleSetLock.Lock()
+	ruleSets[r.Id()] = r
+	ruleSetLock.Unlock()
+}
+
+// GetRuleSet returns the registered rule set or the seeded variant for ids
+// like seed-42, nil is returned if the id is unknown
+func GetRuleSet(id string) RuleSet {
+	ruleSetLock.RLock()
+	r := ruleSets[id]
+	ruleSetLock.RUnlock()
+	if r != nil {
+		return r
+	}
+	if seed, ok := parseSeedId(id); ok {
+		return SeededRules(seed)
+	}
+	return nil
+}
+
+// RuleSetIds lists the ids of every registered rule set
+func RuleSetIds() []string {
+	ruleSetLock.RLock()
+	ids := make([]string, 0, len(ruleSets))
+	for id := range ruleSets {
+		ids = append(ids, id)
+	}
+	ruleSetLock.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,123 @@
+package ktanemod_remote_math_server
+
+import (
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStandardRules_Answers(t *testing.T) {
+	b := Bomb{Fruits: fruits1, FruitText: cText1, Batteries: 2, Ports: 3}
+	assert.Equal(t, Answers{Left: 2, Right: 12, Display: "14+91*5=469", Status: cText1}, DefaultRules.Answers(b))
+
+	// only the changed threshold affects the answers
+	lowBatteries := *DefaultRules
+	lowBatteries.RuleId = "test-low-batteries"
+	lowBatteries.BatteryThreshold = 1
+	assert.Equal(t, Answers{Left: 2, Right: 12, Display: "14+91-5=100", Status: cText1}, lowBatteries.Answers(b))
+}
+
+func TestGetRuleSet(t *testing.T) {
+	assert.Equal(t, DefaultRules, GetRuleSet(DefaultRuleSetId))
+	assert.Nil(t, GetRuleSet("missing"))
+	assert.Contains(t, RuleSetIds(), DefaultRuleSetId)
+}
+
+func TestPuzzle_selectRuleSet(t *testing.T) {
+	lowBatteries := *DefaultRules
+	lowBatteries.RuleId = "test-low-batteries"
+	lowBatteries.BatteryThreshold = 1
+	RegisterRuleSet(&lowBatteries)
+
+	modServer, modClient := testConnPair(t)
+	webServer, webClient := testConnPair(t)
+	legacyServer, legacyClient := testConnPair(t)
+	p := NewPuzzle(modServer, false)
+	p.webConns = append(p.webConns,
+		&WebConn{conn: webServer, features: protocol.Features{Version: 2, Capabilities: []string{protocol.CapabilityRuleSet}}},
+		&WebConn{conn: legacyServer, features: protocol.Features{Version: 1}},
+	)
+	p.batteries = 2
+	p.ports = 3
+	p.fruits = fruits1
+	p.cText = cText1
+
+	// unknown rule sets keep the current rules
+	p.RecvMod("PuzzleRuleSet::missing")
+	assert.Equal(t, "PuzzleRuleSet::default", readText(t, modClient))
+	assert.Equal(t, "PuzzleRuleSet::default", readText(t, webClient))
+
+	p.RecvMod("PuzzleRuleSet::test-low-batteries")
+	assert.Equal(t, "PuzzleRuleSet::test-low-batteries", readText(t, modClient))
+	assert.Equal(t, "PuzzleRuleSet::test-low-batteries", readText(t, webClient))
+	assert.Equal(t, "test-low-batteries", p.Details().RuleSet)
+	assert.True(t, p.CheckSolution(protocol.PuzzleSolution{Left: 2, Right: 12, Display: "14+91-5=100", Status: 0}))
+
+	// the rules can't change after a solution attempt
+	p.RecvWebConn("PuzzleSolution::2::12::14+91-5=100::0")
+	p.RecvMod("PuzzleRuleSet::default")
+	assert.Equal(t, "test-low-batteries", p.RuleSet().Id())
+	assert.Contains(t, p.logRaw.String(), "Rule set default ignored after a solution attempt")
+
+	events, err := ReadEvents(strings.NewReader(p.events.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, EventRuleSet, events[0].Type)
+	assert.Equal(t, "test-low-batteries", events[0].RuleSet)
+
+	// legacy web clients don't receive the rule set
+	p.SendWebConns(protocol.PuzzleComplete{})
+	assert.Equal(t, "PuzzleComplete", readText(t, legacyClient))
+}
+
+func TestPuzzle_selectRuleSet_Concurrent(t *testing.T) {
+	lowBatteries := *DefaultRules
+	lowBatteries.RuleId = "test-low-batteries"
+	lowBatteries.BatteryThreshold = 1
+	RegisterRuleSet(&lowBatteries)
+
+	for i := 0; i < 20; i++ {
+		modServer, _ := testConnPair(t)
+		p := NewPuzzle(modServer, false)
+		p.solveCloseDelay = time.Minute
+		p.batteries = 2
+		p.ports = 3
+		p.fruits = fruits1
+		p.cText = cText1
+
+		// the solution is only correct with the selected rules, so it must be
+		// checked with the rules the puzzle ends up using
+		done := make(chan struct{})
+		go func() {
+			p.RecvMod("PuzzleRuleSet::test-low-batteries")
+			close(done)
+		}()
+		p.RecvWebConn("PuzzleSolution::2::12::14+91-5=100::0")
+		<-done
+		assert.Equal(t, p.RuleSet().Id() == "test-low-batteries", p.solved.Load())
+	}
+}
+
+// swappedStatus only accepts the status light colours the other way around
+type swappedStatus struct{ *StandardRules }
+
+func (s swappedStatus) Answers(b Bomb) Answers {
+	a := s.StandardRules.Answers(b)
+	a.Status = [2]int{5 - b.FruitText[0], 5 - b.FruitText[1]}
+	return a
+}
+
+func TestPuzzle_CheckSolution_RuleSetStatus(t *testing.T) {
+	p := NewPuzzle(nil, false)
+	p.batteries = 2
+	p.ports = 3
+	p.fruits = fruits1
+	p.cText = cText1
+	p.rules = swappedStatus{DefaultRules}
+
+	// step 4 is checked against the status from the rule set
+	assert.Equal(t, StepResults{true, true, true, false}, p.CheckSolutionSteps(protocol.PuzzleSolution{Left: 2, Right: 12, Display: "14+91*5=469", Status: 0}))
+	assert.True(t, p.CheckSolution(protocol.PuzzleSolution{Left: 2, Right: 12, Display: "14+91*5=469", Status: 5}))
+	assert.Contains(t, p.logRaw.String(), "Step 4: 5 or 4")
+}
@@ -0,0 +1,111 @@
+package ktanemod_remote_math_server
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SeedRuleSetPrefix is followed by the rule seed in the id of seeded rule sets
+const SeedRuleSetPrefix = "seed-"
+
+var manualTemplate = template.Must(template.ParseFS(templateFiles, "templates/manual.html"))
+
+// SeededRules derives a variant of the manual from the rule seed, seed 1 is
+// the original manual
+//
+// The fruit numbers and step constants change with the seed, the modulo and
+// step 2 offset stay the same so the answers fit on the module.
+func SeededRules(seed int) *StandardRules {
+	if seed == 1 {
+		return DefaultRules
+	}
+	rng := rand.New(rand.NewSource(int64(seed)))
+	r := &StandardRules{
+		RuleId:      SeedRuleSetPrefix + strconv.Itoa(seed),
+		Modulo:      DefaultRules.Modulo,
+		Step2Offset: DefaultRules.Step2Offset,
+	}
+	// every fruit gets a different number from 1 to 99
+	numbers := rng.Perm(99)
+	for i := range r.FruitNumbers {
+		for j := range r.FruitNumbers[i] {
+			r.FruitNumbers[i][j] = numbers[i*len(r.FruitNumbers[i])+j] + 1
+		}
+	}
+	r.Step1Multiplier = 2 + rng.Intn(18)
+	r.Step1Matching = 10 + rng.Intn(31)
+	r.Step2Matching = 20 + rng.Intn(61)
+	r.BatteryThreshold = 2 + rng.Intn(5)
+	return r
+}
+
+// parseSeedId reads the rule seed from ids like seed-42
+func parseSeedId(id string) (int, bool) {
+	s, ok := strings.CutPrefix(id, SeedRuleSetPrefix)
+	if !ok {
+		return 0, false
+	}
+	return parseSeed(s)
+}
+
+// parseSeed only accepts seeds written without leading zeros so each seed has
+// a single rule set id
+func parseSeed(s string) (int, bool) {
+	seed, err := strconv.Atoi(s)
+	if err != nil || seed < 1 || seed > math.MaxInt32 || strconv.Itoa(seed) != s {
+		return 0, false
+	}
+	return seed, true
+}
+
+// ManualData is the manual for a rule seed
+type ManualData struct {
+	Seed   int      `json:"seed"`
+	Fruits []string `json:"fruits"`
+	*StandardRules
+}
+
+// NewManualData returns the manual for the rule seed
+func NewManualData(seed int) ManualData {
+	return ManualData{Seed: seed, Fruits: fruitNames, StandardRules: SeededRules(seed)}
+}
+
+// manualSeed reads the seed query parameter, seed 1 is used when it is missing
+func manualSeed(rw http.ResponseWriter, req *http.Request) (int, bool) {
+	v := req.URL.Query().Get("seed")
+	if v == "" {
+		return 1, true
+	}
+	seed, ok := parseSeed(v)
+	if !ok {
+		http.Error(rw, "seed must be a number from 1 to "+strconv.Itoa(math.MaxInt32), http.StatusBadRequest)
+	}
+	return seed, ok
+}
+
+func (s *Server) handleApiManual(rw http.ResponseWriter, req *http.Request) {
+	if seed, ok := manualSeed(rw, req); ok {
+		writeJson(rw, NewManualData(seed))
+	}
+}
+
+func (s *Server) handleManual(rw http.ResponseWriter, req *http.Request) {
+	seed, ok := manualSeed(rw, req)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := manualTemplate.Execute(&buf, NewManualData(seed)); err != nil {
+		log.Printf("[RemoteMath] Failed to render manual for seed %d: %s\n", seed, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(rw)
+}
@@ -0,0 +1,97 @@
+package ktanemod_remote_math_server
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSeededRules(t *testing.T) {
+	assert.Equal(t, DefaultRules, SeededRules(1))
+
+	// the variant for a seed must never change or manuals stop matching
+	assert.Equal(t, &StandardRules{
+		RuleId: "seed-42",
+		FruitNumbers: [6][6]int{
+			{53, 6, 15, 95, 69, 18},
+			{80, 33, 31, 5, 4, 38},
+			{59, 23, 49, 3, 76, 2},
+			{86, 58, 97, 54, 93, 96},
+			{89, 26, 78, 40, 9, 73},
+			{46, 84, 64, 14, 88, 77},
+		},
+		Step1Multiplier:  2,
+		Step1Matching:    14,
+		Step2Matching:    30,
+		Modulo:           20,
+		Step2Offset:      5,
+		BatteryThreshold: 5,
+	}, SeededRules(42))
+	assert.Equal(t, Answers{Left: 0, Right: 18, Display: "18+4*58=250", Status: cText1}, SeededRules(42).Answers(Bomb{Fruits: fruits1, FruitText: cText1, Batteries: 2, Ports: 3}))
+
+	for _, seed := range []int{2, 3, 1000, 2147483647} {
+		r := SeededRules(seed)
+		seen := make(map[int]bool)
+		for _, row := range r.FruitNumbers {
+			for _, n := range row {
+				assert.False(t, seen[n], "seed %d repeats fruit number %d", seed, n)
+				assert.True(t, n >= 1 && n <= 99)
+				seen[n] = true
+			}
+		}
+		assert.True(t, r.BatteryThreshold >= 2 && r.BatteryThreshold <= 6)
+	}
+}
+
+func TestGetRuleSet_Seed(t *testing.T) {
+	assert.Equal(t, "seed-42", GetRuleSet("seed-42").Id())
+	assert.Equal(t, DefaultRules, GetRuleSet("seed-1"))
+	for _, id := range []string{"seed-", "seed-0", "seed-042", "seed--1", "seed-2147483648", "seed-a"} {
+		assert.Nil(t, GetRuleSet(id), id)
+	}
+
+	modServer, modClient := testConnPair(t)
+	p := NewPuzzle(modServer, false)
+	p.batteries = 2
+	p.ports = 3
+	p.fruits = fruits1
+	p.cText = cText1
+	p.RecvMod("PuzzleRuleSet::seed-42")
+	assert.Equal(t, "PuzzleRuleSet::seed-42", readText(t, modClient))
+	assert.True(t, p.CheckSolution(protocol.PuzzleSolution{Left: 0, Right: 18, Display: "18+4*58=250", Status: 1}))
+}
+
+func TestServer_handleManual(t *testing.T) {
+	s := testServer(t, testConfig(t))
+
+	rec := httptest.NewRecorder()
+	s.handleApiManual(rec, httptest.NewRequest(http.MethodGet, "/api/manual?seed=42", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var m ManualData
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&m))
+	assert.Equal(t, NewManualData(42), m)
+
+	rec = httptest.NewRecorder()
+	s.handleApiManual(rec, httptest.NewRequest(http.MethodGet, "/api/manual", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	m = ManualData{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&m))
+	assert.Equal(t, 1, m.Seed)
+	assert.Equal(t, DefaultRuleSetId, m.RuleId)
+
+	rec = httptest.NewRecorder()
+	s.handleManual(rec, httptest.NewRequest(http.MethodGet, "/manual?seed=42", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "Rule seed 42, rule set <code>seed-42</code>")
+	assert.Contains(t, rec.Body.String(), `<tr><th>Apple</th><td class="number">53</td>`)
+
+	for _, seed := range []string{"0", "-1", "abc", "2147483648"} {
+		rec = httptest.NewRecorder()
+		s.handleManual(rec, httptest.NewRequest(http.MethodGet, "/manual?seed="+seed, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, seed)
+	}
+}
@@ -4,22 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	exitReload "github.com/mrmelon54/exit-reload"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
 	"github.com/gorilla/websocket"
+	exitReload "github.com/mrmelon54/exit-reload"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(req *http.Request) bool {
-		h := req.URL.Hostname()
-		return h == "remote-math.mrmelon54.com" || h == "localhost" || h == "127.0.0.1" || h == ""
+// serverFeatures is the newest protocol version and all capabilities supported
+// by the server, clients get the subset they also support
+var serverFeatures = protocol.Features{
+	Version: protocol.CurrentVersion,
+	Capabilities: []string{
+		protocol.CapabilityResume,
+		protocol.CapabilityControlPing,
+		protocol.CapabilityNotice,
+		protocol.CapabilityRuleSet,
+		protocol.CapabilityStrike,
+		protocol.CapabilityTraining,
 	},
 }
 
@@ -27,32 +36,54 @@ var regLogDate = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}$")
 var regLogCode = regexp.MustCompile("^[a-zA-Z]{6}$")
 
 type Server struct {
-	Listen      string
-	LogDir      string
-	DebugPuzzle bool
-	rm          *RemoteMath
-	mLock       *sync.RWMutex
-	m           map[string]*websocket.Conn
-	pingStop    chan struct{}
+	Config *Config
+	// Reload is called to load the new config when a reload signal is received
+	Reload   func() (*Config, error)
+	conf     *atomic.Pointer[Config]
+	certs    *certStore
+	started  time.Time
+	stopping *atomic.Bool
+	rm       *RemoteMath
+	upgrader websocket.Upgrader
+	mLock    *sync.RWMutex
+	m        map[string]*Conn
 }
 
 func (s *Server) Run() {
+	s.started = time.Now()
+	s.stopping = new(atomic.Bool)
 	random := rand.New(rand.NewSource(time.Now().UnixNano()))
-	s.rm = NewRemoteMath(random, s.LogDir, s.DebugPuzzle)
+	s.conf = new(atomic.Pointer[Config])
+	s.conf.Store(s.Config)
+	logs, err := OpenLogStore(s.Config)
+	if err != nil {
+		log.Fatalln("[RemoteMath] Error trying to open the log store: ", err)
+	}
+	s.rm = NewRemoteMath(random, s.Config, logs)
+	if s.Config.Signing.KeyFile != "" {
+		key, err := LoadSigningKey(s.Config.Signing.KeyFile)
+		if err != nil {
+			log.Fatalln("[RemoteMath] Error trying to load the log signing key: ", err)
+		}
+		s.rm.SetSigningKey(key)
+	}
+	janitorStop := make(chan struct{})
+	go s.runJanitor(janitorStop)
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin}
 	s.mLock = new(sync.RWMutex)
-	s.m = make(map[string]*websocket.Conn)
-	s.pingStop = make(chan struct{}, 1)
-	s.StartPinger()
+	s.m = make(map[string]*Conn)
 
 	r := http.NewServeMux()
 	r.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
 		if websocket.IsWebSocketUpgrade(req) {
 			log.Printf("[Websocket] Upgrading connection by '%s' from '%s'\n", req.RemoteAddr, req.Header.Get("Origin"))
-			c, err := upgrader.Upgrade(rw, req, nil)
+			ws, err := s.upgrader.Upgrade(rw, req, nil)
 			if err != nil {
 				log.Println("[Websocket] Upgrade error: ", err)
 				return
 			}
+			c := NewConn(ws, s.conf.Load().PingConfig())
+			c.origin = req.Header.Get("Origin")
 			s.mLock.Lock()
 			s.m[c.RemoteAddr().String()] = c
 			s.mLock.Unlock()
@@ -63,35 +94,61 @@ func (s *Server) Run() {
 		_, _ = rw.Write([]byte("What is a \"Remote Math\" anyway?\n"))
 	})
 
-	r.HandleFunc("/log", func(rw http.ResponseWriter, req *http.Request) {
-		q := req.URL.Query()
-		date := q.Get("date")
-		code := q.Get("code")
-		if !regLogDate.MatchString(date) || !regLogCode.MatchString(code) {
-			rw.WriteHeader(http.StatusNotFound)
-			return
-		}
-		logFile := filepath.Join(s.LogDir, date, strings.ToUpper(code)+".log")
-		if strings.Contains(logFile, "..") || !strings.HasPrefix(logFile, s.LogDir) {
-			rw.WriteHeader(http.StatusNotFound)
-			return
-		}
-		http.ServeFile(rw, req, logFile)
-	})
+	r.HandleFunc("/log", s.handleLog)
+	r.HandleFunc("/log/verify", s.handleLogVerify)
+	r.HandleFunc("/log/key", s.handleLogKey)
+	r.HandleFunc("/manual", s.handleManual)
+
+	r.HandleFunc("/api/status", s.handleStatus)
+	r.HandleFunc("/api/manual", s.handleApiManual)
+	r.HandleFunc("/metrics", s.handleMetrics)
+	r.HandleFunc("/healthz", s.handleHealthz)
+	r.HandleFunc("/readyz", s.handleReadyz)
+	r.HandleFunc("/api/admin/puzzles", s.adminAuth(http.MethodGet, s.handleAdminPuzzles))
+	r.HandleFunc("/api/admin/puzzle", s.adminAuth(http.MethodGet, s.handleAdminPuzzle))
+	r.HandleFunc("/api/admin/kill", s.adminAuth(http.MethodPost, s.handleAdminKill))
+	r.HandleFunc("/api/admin/disconnect", s.adminAuth(http.MethodPost, s.handleAdminDisconnect))
+	r.HandleFunc("/api/admin/save-log", s.adminAuth(http.MethodPost, s.handleAdminSaveLog))
+	r.HandleFunc("/api/admin/notice", s.adminAuth(http.MethodPost, s.handleAdminNotice))
+	r.HandleFunc("/api/admin/logs", s.adminAuth(http.MethodGet, s.handleAdminLogs))
 
 	// setup http listener
 	srv := &http.Server{
-		Addr:              s.Listen,
+		Addr:              s.Config.Listen,
 		Handler:           r,
-		ReadTimeout:       time.Minute,
-		ReadHeaderTimeout: time.Minute,
-		WriteTimeout:      time.Minute,
-		IdleTimeout:       time.Minute,
-		MaxHeaderBytes:    2500,
+		ReadTimeout:       s.Config.HTTP.ReadTimeout,
+		ReadHeaderTimeout: s.Config.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      s.Config.HTTP.WriteTimeout,
+		IdleTimeout:       s.Config.HTTP.IdleTimeout,
+		MaxHeaderBytes:    s.Config.HTTP.MaxHeaderBytes,
 	}
-	log.Printf("[RemoteMath] Hosting Remote Math on '%s'\n", srv.Addr)
+	var redirectSrv *http.Server
+	if s.Config.TLSEnabled() {
+		s.certs = newCertStore()
+		if err := s.certs.Load(s.Config.TLS.Cert, s.Config.TLS.Key); err != nil {
+			log.Fatalln("[RemoteMath] Error trying to start TLS: ", err)
+		}
+		srv.TLSConfig = s.certs.TLSConfig()
+		if s.Config.TLS.RedirectListen != "" {
+			redirectSrv = &http.Server{
+				Addr:              s.Config.TLS.RedirectListen,
+				Handler:           httpsRedirect(s.Config.Listen),
+				ReadTimeout:       s.Config.HTTP.ReadTimeout,
+				ReadHeaderTimeout: s.Config.HTTP.ReadHeaderTimeout,
+				WriteTimeout:      s.Config.HTTP.WriteTimeout,
+				IdleTimeout:       s.Config.HTTP.IdleTimeout,
+				MaxHeaderBytes:    s.Config.HTTP.MaxHeaderBytes,
+			}
+		}
+	}
+	log.Printf("[RemoteMath] Hosting Remote Math on '%s' (TLS: %v)\n", srv.Addr, s.certs != nil)
 	go func() {
-		err := srv.ListenAndServe()
+		var err error
+		if s.certs != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
 		if err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
 				log.Println("[RemoteMath] The http server shutdown successfully")
@@ -100,8 +157,30 @@ func (s *Server) Run() {
 			}
 		}
 	}()
-	exitReload.ExitReload("RemoteMath", func() {}, func() {
-		close(s.pingStop)
+	if redirectSrv != nil {
+		log.Printf("[RemoteMath] Redirecting http to https on '%s'\n", redirectSrv.Addr)
+		go func() {
+			err := redirectSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalln("[RemoteMath] Error trying to host the http redirect server: ", err)
+			}
+		}()
+	}
+	exitReload.ExitReload("RemoteMath", s.reloadConfig, func() {
+		// fail the readiness check so load balancers stop sending new clients
+		s.stopping.Store(true)
+		conf := s.conf.Load()
+		if d := conf.Shutdown.ReadyDelay; d > 0 {
+			log.Printf("[RemoteMath] Waiting %s before closing connections\n", d)
+			time.Sleep(d)
+		}
+
+		// let active puzzles finish then kill the rest and save their logs
+		if !s.rm.Drain(conf.Shutdown.DrainTimeout) {
+			log.Println("[RemoteMath] Drain timeout reached, killing the remaining puzzles")
+		}
+		s.rm.Close()
+		close(janitorStop)
 
 		// close all websockets connections
 		s.mLock.Lock()
@@ -109,39 +188,164 @@ func (s *Server) Run() {
 		for _, i := range s.m {
 			fmt.Printf("Closing connection %s, %s, %s\n", i.LocalAddr(), i.RemoteAddr(), i.Subprotocol())
 			_ = i.Close()
+			i.Wait()
 			fmt.Println("Closed")
 		}
-		s.m = make(map[string]*websocket.Conn)
+		s.m = make(map[string]*Conn)
 		s.mLock.Unlock()
 
-		// close remote math handler
-		s.rm.Close()
+		if redirectSrv != nil {
+			_ = redirectSrv.Shutdown(context.Background())
+		}
 		_ = srv.Shutdown(context.Background())
+		if closer, ok := logs.(io.Closer); ok {
+			_ = closer.Close()
+		}
 	})
 }
 
-func (s *Server) StartPinger() {
-	go func() {
-		t := time.NewTicker(5 * time.Second)
-		defer t.Stop()
-	outer:
-		for {
-			select {
-			case <-s.pingStop:
-				break outer
-			case <-t.C:
-				s.mLock.RLock()
-				for _, v := range s.m {
-					if v == nil {
-						continue
-					}
-					_ = v.WriteMessage(websocket.TextMessage, []byte("ping"))
-				}
-				s.mLock.RUnlock()
+// handleLog serves a saved puzzle log from the LogStore, the structured event
+// log is returned when the format is json
+func (s *Server) handleLog(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	id := LogId{Date: q.Get("date"), Code: strings.ToUpper(q.Get("code")), Kind: LogText}
+	contentType := "text/plain; charset=utf-8"
+	switch q.Get("format") {
+	case "", "text":
+	case "json":
+		id.Kind = LogEvents
+		contentType = "application/x-ndjson"
+	case "html":
+	default:
+		http.Error(rw, "Unknown log format", http.StatusBadRequest)
+		return
+	}
+	if !id.Valid() {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if q.Get("format") == "html" {
+		s.handleLogView(rw, id)
+		return
+	}
+	if compressor, ok := s.rm.logs.(logCompressor); ok {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(req) {
+			// send the compressed log as it is
+			f, info, err := compressor.OpenCompressed(id)
+			if err == nil {
+				defer f.Close()
+				rw.Header().Set("Content-Type", contentType)
+				rw.Header().Set("Content-Encoding", "gzip")
+				http.ServeContent(rw, req, "", info.ModTime, f)
+				return
 			}
 		}
-		fmt.Println("[Remote Math] Background ping sender stopped")
-	}()
+	}
+	f, info, err := s.rm.logs.Open(id)
+	if errors.Is(err, ErrLogNotFound) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("[RemoteMath] Failed to open log %s: %s\n", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	rw.Header().Set("Content-Type", contentType)
+	http.ServeContent(rw, req, id.Code+"."+string(id.Kind), info.ModTime, f)
+}
+
+// acceptsGzip returns true if the client allows a gzip response
+func acceptsGzip(req *http.Request) bool {
+	for _, i := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(i), ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		return !ok || (q != "0" && strings.Trim(q, "0.") != "")
+	}
+	return false
+}
+
+// reloadConfig loads the new config and applies the settings which can change
+// without a restart, the old config is kept if the new one is invalid
+func (s *Server) reloadConfig() {
+	if s.Reload == nil {
+		log.Println("[RemoteMath] Reload is not supported")
+		return
+	}
+	conf, err := s.Reload()
+	if err == nil {
+		err = conf.Validate()
+	}
+	if err != nil {
+		log.Printf("[RemoteMath] Failed to reload config, keeping the current config: %s\n", err)
+		return
+	}
+
+	s.reloadCert(conf)
+
+	old := s.conf.Load()
+	changes := conf.Changes(old)
+	if len(changes) == 0 {
+		log.Println("[RemoteMath] Reloaded config without any changes")
+		return
+	}
+	for _, i := range changes {
+		if i.Restart {
+			log.Printf("[RemoteMath] Config %s changed from %v to %v but requires a restart\n", i.Field, i.Old, i.New)
+		} else {
+			log.Printf("[RemoteMath] Config %s changed from %v to %v\n", i.Field, i.Old, i.New)
+		}
+	}
+	s.conf.Store(conf)
+	s.rm.SetConfig(conf)
+}
+
+// reloadCert reads the TLS certificate from disk again, switching between http
+// and https requires a restart
+func (s *Server) reloadCert(conf *Config) {
+	switch {
+	case s.certs == nil && conf.TLSEnabled():
+		log.Println("[RemoteMath] Enabling TLS requires a restart")
+	case s.certs != nil && !conf.TLSEnabled():
+		log.Println("[RemoteMath] Disabling TLS requires a restart, keeping the current certificate")
+	case s.certs != nil:
+		if err := s.certs.Load(conf.TLS.Cert, conf.TLS.Key); err != nil {
+			log.Printf("[RemoteMath] Keeping the current certificate: %s\n", err)
+			return
+		}
+		log.Println("[RemoteMath] Reloaded TLS certificate")
+	}
+}
+
+// checkOrigin allows the upgrade if the origin is allowed for either client
+// type, the client type is checked again after the handshake
+func (s *Server) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	conf := s.conf.Load()
+	return conf.ModuleOrigins().Match(origin) || conf.WebOrigins().Match(origin)
+}
+
+// checkClientOrigin makes sure the origin is allowed for the selected client type
+func (s *Server) checkClientOrigin(c *Conn, packet protocol.Packet) bool {
+	conf := s.conf.Load()
+	var rules OriginRules
+	switch packet.(type) {
+	case protocol.SelectModule, protocol.PuzzleResume:
+		rules = conf.ModuleOrigins()
+	case protocol.SelectWeb:
+		rules = conf.WebOrigins()
+	default:
+		return true
+	}
+	if rules.Match(c.origin) {
+		return true
+	}
+	log.Printf("[Websocket] Origin '%s' is not allowed to select '%s'\n", c.origin, packet.Name())
+	return false
 }
 
 // State value
@@ -159,7 +363,7 @@ const (
 	WebClientPostConnect
 )
 
-func (s *Server) websocketHandler(c *websocket.Conn) {
+func (s *Server) websocketHandler(c *Conn) {
 	defer func() {
 		s.mLock.Lock()
 		delete(s.m, c.RemoteAddr().String())
@@ -169,6 +373,7 @@ func (s *Server) websocketHandler(c *websocket.Conn) {
 
 	var state = NewConnection
 	var puzzle *Puzzle
+	var features protocol.Features
 
 	for {
 		mt, message, err := c.ReadMessage()
@@ -181,46 +386,77 @@ func (s *Server) websocketHandler(c *websocket.Conn) {
 		}
 		switch state {
 		case NewConnection:
-			if string(message) == "pong" {
+			packet, err := protocol.Decode(protocol.Handshake, string(message))
+			if err != nil {
 				break
 			}
-			switch string(message) {
-			case "blåhaj":
+			if !s.checkClientOrigin(c, packet) {
+				return
+			}
+			switch packet := packet.(type) {
+			case protocol.SelectModule:
+				state = ModuleClient
+				features = serverFeatures.Negotiate(packet.Features)
+				c.Send(protocol.ClientSelected{Features: features})
+				puzzle = s.rm.CreatePuzzle(c, features)
+				if puzzle == nil {
+					// the server is shutting down
+					_ = c.Close()
+					return
+				}
+				puzzle.SendMod(protocol.PuzzleCode{Code: puzzle.code})
+				puzzle.SendMod(protocol.PuzzleLog{Message: "LogFile/" + puzzle.date.Format(time.DateOnly) + "/" + puzzle.code})
+				if puzzle.resumeToken != "" {
+					puzzle.SendMod(protocol.PuzzleResumeToken{Token: puzzle.resumeToken})
+				}
+			case protocol.PuzzleResume:
+				puzzle = s.rm.ResumePuzzle(c, packet)
+				if puzzle == nil {
+					c.Send(protocol.PuzzleResumeFailed{})
+					break
+				}
 				state = ModuleClient
-				_ = c.WriteMessage(websocket.TextMessage, []byte("ClientSelected"))
-				puzzle = s.rm.CreatePuzzle(c)
-				puzzle.SendMod("PuzzleCode::" + puzzle.code)
-				puzzle.SendMod("PuzzleLog::LogFile/" + puzzle.date.Format(time.DateOnly) + "/" + puzzle.code)
-			case "rin":
+				features = puzzle.modFeatures
+			case protocol.SelectWeb:
 				state = WebClientPreConnect
-				_ = c.WriteMessage(websocket.TextMessage, []byte("ClientSelected"))
+				features = serverFeatures.Negotiate(packet.Features)
+				c.Send(protocol.ClientSelected{Features: features})
 			}
-		case ModuleClient:
-			if string(message) == "pong" {
-				break
+			if state == ModuleClient {
+				s.rm.metrics.ModuleConns.Add(1)
+			}
+			if state != NewConnection {
+				// clients replying to control frame pings don't need the text ping
+				c.SetLegacyPing(!features.Has(protocol.CapabilityControlPing))
 			}
+		case ModuleClient:
 			puzzle.RecvMod(string(message))
 		case WebClientPreConnect:
-			if string(message) == "pong" {
-				break
+			packet, _ := protocol.Decode(protocol.FromWeb, string(message))
+			switch packet := packet.(type) {
+			case protocol.Pong:
+				continue
+			case protocol.PuzzleConnect:
+				puzzle = s.rm.ConnectPuzzle(c, packet, features)
+			case protocol.PuzzleRejoin:
+				puzzle = s.rm.RejoinPuzzle(c, packet, features)
 			}
-			puzzle = s.rm.ConnectPuzzle(c, string(message))
 			if puzzle == nil {
 				_ = c.Close()
 				return
 			}
 			state = WebClientPostConnect
+			s.rm.metrics.WebConns.Add(1)
 		case WebClientPostConnect:
-			if string(message) == "pong" {
-				break
-			}
 			puzzle.RecvWebConn(string(message))
 		}
 	}
 	switch state {
 	case ModuleClient:
-		s.rm.ClosePuzzle(puzzle)
+		s.rm.metrics.ModuleConns.Add(-1)
+		s.rm.DetachPuzzle(puzzle, c)
 	case WebClientPostConnect:
+		s.rm.metrics.WebConns.Add(-1)
 		puzzle.RemoveWebConn(c)
 	}
 }
@@ -0,0 +1,38 @@
+package ktanemod_remote_math_server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func testServer(t *testing.T, conf *Config) *Server {
+	s := &Server{Config: conf, conf: new(atomic.Pointer[Config]), stopping: new(atomic.Bool), mLock: new(sync.RWMutex), m: make(map[string]*Conn)}
+	s.conf.Store(conf)
+	s.rm = NewRemoteMath(rand.New(rand.NewSource(1)), conf, NewFSLogStore(conf.LogDir))
+	return s
+}
+
+func TestServer_reloadConfig(t *testing.T) {
+	conf := testConfig(t)
+	s := testServer(t, conf)
+
+	// invalid configs are ignored
+	s.Reload = func() (*Config, error) { return nil, errors.New("broken") }
+	s.reloadConfig()
+	assert.Equal(t, conf, s.conf.Load())
+	s.Reload = func() (*Config, error) { return &Config{}, nil }
+	s.reloadConfig()
+	assert.Equal(t, conf, s.conf.Load())
+
+	newConf := testConfig(t)
+	newConf.Debug = true
+	newConf.Origins.Web = []string{"example.com"}
+	s.Reload = func() (*Config, error) { return newConf, nil }
+	s.reloadConfig()
+	assert.Equal(t, newConf, s.conf.Load())
+	assert.Equal(t, newConf, s.rm.conf.Load())
+}
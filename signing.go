@@ -0,0 +1,232 @@
+package ktanemod_remote_math_server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrLogNotSigned is returned when a log has no saved signature
+var ErrLogNotSigned = errors.New("log not signed")
+
+// ErrInvalidSignature is returned when the signature doesn't match the log
+var ErrInvalidSignature = errors.New("invalid log signature")
+
+// signaturePrefix is added to the start of the signed data so log signatures
+// can't be used for anything else
+const signaturePrefix = "ktanemod-remote-math-log\x00"
+
+// signedData is the message signed for the log, the log id is included so a
+// signed log can't be passed off as a different puzzle
+func signedData(id LogId, data []byte) []byte {
+	b := make([]byte, 0, len(signaturePrefix)+len(id.String())+1+len(data))
+	b = append(b, signaturePrefix...)
+	b = append(b, id.String()...)
+	b = append(b, 0)
+	return append(b, data...)
+}
+
+// SignLog returns the base64 encoded signature saved alongside the log
+func SignLog(key ed25519.PrivateKey, id LogId, data []byte) []byte {
+	sig := ed25519.Sign(key, signedData(id, data))
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// VerifyLog checks the base64 encoded signature matches the log
+func VerifyLog(key ed25519.PublicKey, id LogId, data, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(key, signedData(id, data), raw) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// LoadSigningKey reads the PEM encoded Ed25519 private key, a new key is
+// generated and saved when the file doesn't exist
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateSigningKey(path)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key '%s' is not a PEM encoded private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key '%s': %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key '%s' is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+func generateSigningKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save signing key '%s': %w", path, err)
+	}
+	log.Printf("[RemoteMath] Generated a new log signing key '%s'\n", path)
+	return key, nil
+}
+
+// ParseVerifyKey reads the Ed25519 public key from a PEM encoded public or
+// private key
+func ParseVerifyKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unknown key type '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey), nil
+	}
+	return nil, errors.New("key is not an Ed25519 key")
+}
+
+// encodePublicKey returns the PEM encoded public key
+func encodePublicKey(key ed25519.PublicKey) []byte {
+	der, _ := x509.MarshalPKIXPublicKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// SetSigningKey enables signing saved logs, it must be called before any
+// puzzles are created
+func (r *RemoteMath) SetSigningKey(key ed25519.PrivateKey) {
+	r.signKey = key
+}
+
+// saveSigned saves the log and its signature when signing is enabled
+func (r *RemoteMath) saveSigned(id LogId, data []byte) error {
+	if err := r.logs.Save(id, data); err != nil {
+		return err
+	}
+	if r.signKey == nil {
+		return nil
+	}
+	return r.logs.Save(id.SignatureId(), SignLog(r.signKey, id, data))
+}
+
+// VerifySavedLog checks the saved log against its saved signature
+func (r *RemoteMath) VerifySavedLog(id LogId) error {
+	if r.signKey == nil {
+		return ErrLogNotSigned
+	}
+	data, err := readLog(r.logs, id)
+	if err != nil {
+		return err
+	}
+	sig, err := readLog(r.logs, id.SignatureId())
+	if errors.Is(err, ErrLogNotFound) {
+		return ErrLogNotSigned
+	} else if err != nil {
+		return err
+	}
+	return VerifyLog(r.signKey.Public().(ed25519.PublicKey), id, data, sig)
+}
+
+func readLog(store LogStore, id LogId) ([]byte, error) {
+	f, _, err := store.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// LogVerification is the result of the log verify endpoint
+type LogVerification struct {
+	Date string `json:"date"`
+	Code string `json:"code"`
+	// Valid is true when every saved log of the puzzle has a valid signature
+	Valid bool               `json:"valid"`
+	Logs  []LogKindSignature `json:"logs"`
+}
+
+// LogKindSignature is the signature check for a single kind of log
+type LogKindSignature struct {
+	Kind  LogKind `json:"kind"`
+	Valid bool    `json:"valid"`
+	Error string  `json:"error,omitempty"`
+}
+
+func (s *Server) handleLogVerify(rw http.ResponseWriter, req *http.Request) {
+	if s.rm.signKey == nil {
+		http.Error(rw, "Log signing is disabled", http.StatusNotFound)
+		return
+	}
+	q := req.URL.Query()
+	result := LogVerification{Date: q.Get("date"), Code: strings.ToUpper(q.Get("code")), Valid: true}
+	for _, kind := range []LogKind{LogText, LogEvents} {
+		id := LogId{Date: result.Date, Code: result.Code, Kind: kind}
+		if !id.Valid() {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		err := s.rm.VerifySavedLog(id)
+		if errors.Is(err, ErrLogNotFound) {
+			continue
+		}
+		v := LogKindSignature{Kind: kind, Valid: err == nil}
+		if err != nil {
+			if !errors.Is(err, ErrLogNotSigned) && !errors.Is(err, ErrInvalidSignature) {
+				log.Printf("[RemoteMath] Failed to verify log %s: %s\n", id, err)
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			v.Error = err.Error()
+			result.Valid = false
+		}
+		result.Logs = append(result.Logs, v)
+	}
+	if len(result.Logs) == 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJson(rw, result)
+}
+
+func (s *Server) handleLogKey(rw http.ResponseWriter, _ *http.Request) {
+	if s.rm.signKey == nil {
+		http.Error(rw, "Log signing is disabled", http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = rw.Write(encodePublicKey(s.rm.signKey.Public().(ed25519.PublicKey)))
+}
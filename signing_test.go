@@ -0,0 +1,108 @@
+package ktanemod_remote_math_server
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignLog(t *testing.T) {
+	key, err := LoadSigningKey(filepath.Join(t.TempDir(), "signing.pem"))
+	assert.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+	id := LogId{Date: "2023-01-02", Code: "ABCDEF", Kind: LogText}
+	data := []byte("Module ID: ABCDEF\n")
+	sig := SignLog(key, id, data)
+
+	assert.NoError(t, VerifyLog(pub, id, data, sig))
+	assert.ErrorIs(t, VerifyLog(pub, id, []byte("Module ID: ABCDEG\n"), sig), ErrInvalidSignature)
+	// the signature only matches the original puzzle
+	assert.ErrorIs(t, VerifyLog(pub, LogId{Date: "2023-01-02", Code: "ABCDEG", Kind: LogText}, data, sig), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyLog(pub, id, data, []byte("not base64")), ErrInvalidSignature)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "signing.pem")
+	key, err := LoadSigningKey(p)
+	assert.NoError(t, err)
+	stat, err := os.Stat(p)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	// the saved key is loaded again
+	key2, err := LoadSigningKey(p)
+	assert.NoError(t, err)
+	assert.Equal(t, key, key2)
+
+	pub, err := ParseVerifyKey(encodePublicKey(key.Public().(ed25519.PublicKey)))
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), pub)
+	data, _ := os.ReadFile(p)
+	pub, err = ParseVerifyKey(data)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), pub)
+
+	assert.NoError(t, os.WriteFile(p, []byte("broken"), 0600))
+	_, err = LoadSigningKey(p)
+	assert.Error(t, err)
+}
+
+func TestServer_handleLogVerify(t *testing.T) {
+	s := testServer(t, testConfig(t))
+	rec := httptest.NewRecorder()
+	s.handleLogVerify(rec, httptest.NewRequest(http.MethodGet, "/log/verify?date=2023-01-02&code=ABCDEF", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	key, err := LoadSigningKey(filepath.Join(t.TempDir(), "signing.pem"))
+	assert.NoError(t, err)
+	s.rm.SetSigningKey(key)
+	s.rm.rId = rand.New(rand.NewSource(1))
+	modServer, _ := testConnPair(t)
+	p := s.rm.CreatePuzzle(modServer, protocol.Features{Version: 1})
+	p.saveLog.Store(true)
+	s.rm.ClosePuzzle(p)
+	target := "/log/verify?date=" + p.LogId(LogText).Date + "&code=" + p.code
+	verify := func() LogVerification {
+		rec := httptest.NewRecorder()
+		s.handleLogVerify(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var v LogVerification
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&v))
+		return v
+	}
+	assert.Equal(t, LogVerification{Date: p.LogId(LogText).Date, Code: p.code, Valid: true, Logs: []LogKindSignature{
+		{Kind: LogText, Valid: true},
+		{Kind: LogEvents, Valid: true},
+	}}, verify())
+
+	// edit the saved log
+	assert.NoError(t, s.rm.logs.Save(p.LogId(LogText), []byte("Solved: true\n")))
+	v := verify()
+	assert.False(t, v.Valid)
+	assert.Equal(t, LogKindSignature{Kind: LogText, Error: ErrInvalidSignature.Error()}, v.Logs[0])
+	assert.True(t, v.Logs[1].Valid)
+
+	assert.NoError(t, s.rm.logs.Delete(p.LogId(LogEvents).SignatureId()))
+	v = verify()
+	assert.Equal(t, LogKindSignature{Kind: LogEvents, Error: ErrLogNotSigned.Error()}, v.Logs[1])
+
+	rec = httptest.NewRecorder()
+	s.handleLogVerify(rec, httptest.NewRequest(http.MethodGet, "/log/verify?date=2023-01-02&code=ABCDEF", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.handleLogKey(rec, httptest.NewRequest(http.MethodGet, "/log/key", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	data, _ := io.ReadAll(rec.Body)
+	pub, err := ParseVerifyKey(data)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), pub)
+}
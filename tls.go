@@ -0,0 +1,63 @@
+package ktanemod_remote_math_server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// certStore holds the TLS certificate so it can be replaced without a restart
+type certStore struct {
+	cert *atomic.Pointer[tls.Certificate]
+}
+
+func newCertStore() *certStore {
+	return &certStore{cert: new(atomic.Pointer[tls.Certificate])}
+}
+
+// Load reads the certificate and key from disk, the current certificate is
+// kept if loading fails
+func (c *certStore) Load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := c.cert.Load()
+	if cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
+	}
+	return cert, nil
+}
+
+func (c *certStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// httpsRedirect returns a handler which redirects every request to the same
+// path on the https listener
+func httpsRedirect(listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(listen)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		u := *req.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(rw, req, u.String(), http.StatusMovedPermanently)
+	})
+}
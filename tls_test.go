@@ -0,0 +1,80 @@
+package ktanemod_remote_math_server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and key to the directory
+func writeTestCert(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestCertStore_Load(t *testing.T) {
+	dir := t.TempDir()
+	c := newCertStore()
+	_, err := c.GetCertificate(nil)
+	assert.Error(t, err)
+
+	certFile, keyFile := writeTestCert(t, dir, 1)
+	assert.NoError(t, c.Load(certFile, keyFile))
+	cert, err := c.GetCertificate(nil)
+	assert.NoError(t, err)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(1), leaf.SerialNumber.Int64())
+
+	// a renewed certificate on disk replaces the current one
+	writeTestCert(t, dir, 2)
+	assert.NoError(t, c.Load(certFile, keyFile))
+	cert, _ = c.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(2), leaf.SerialNumber.Int64())
+
+	// a broken certificate keeps the current one
+	assert.NoError(t, os.WriteFile(certFile, []byte("broken"), 0600))
+	assert.Error(t, c.Load(certFile, keyFile))
+	cert, _ = c.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(2), leaf.SerialNumber.Int64())
+}
+
+func TestHttpsRedirect(t *testing.T) {
+	rec := httptest.NewRecorder()
+	httpsRedirect(":443").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/log?date=2023-01-02", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://example.com/log?date=2023-01-02", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	httpsRedirect("localhost:8443").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com:8080/", nil))
+	assert.Equal(t, "https://example.com:8443/", rec.Header().Get("Location"))
+}
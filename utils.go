@@ -1,11 +1,17 @@
 package ktanemod_remote_math_server
 
 import (
-	"math/rand"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/MrMelon54/ktanemod-remote-math-server/protocol"
+	mathRand "math/rand"
 	"strings"
+	"sync"
 )
 
-func MakeId(r *rand.Rand, l int, chars string) string {
+func MakeId(r *mathRand.Rand, l int, chars string) string {
 	var s strings.Builder
 	s.Grow(l)
 	for i := 0; i < l; i++ {
@@ -14,3 +20,42 @@ func MakeId(r *rand.Rand, l int, chars string) string {
 	}
 	return s.String()
 }
+
+// MakeToken generates a random token used to resume a connection
+func MakeToken() string {
+	b := make([]byte, protocol.TokenLength/2)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// checkToken compares the tokens in constant time, an empty expected token
+// never matches
+func checkToken(expected, actual string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+// logBuffer is a buffer which can be read while the puzzle is still writing to it
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the buffer contents
+func (b *logBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func (b *logBuffer) String() string {
+	return string(b.Bytes())
+}